@@ -0,0 +1,223 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Suffix of the temporary files used by compression and archivation.
+const rollingTemporaryFileSuffix = ".tmp"
+
+// archiveOptions are the settings of the rolling writer used for the created archives.
+type archiveOptions struct {
+	fs      FileSystem
+	durable bool // Archives are synced to the disk before they replace the originals
+	perms   FilePermissions
+}
+
+// addFilesToZip adds the given files to the zip archive at archivePath, creating
+// the archive if it does not exist yet. Files are stored under their base names.
+// Roll numbering may start over when the history is emptied, so a name that is
+// already in the archive gets a "~2", "~3", ... suffix instead of shadowing the
+// older entry.
+//
+// The archive is rebuilt in a temporary file next to it and then renamed over the
+// old one, so a failure in the middle never leaves a truncated archive behind.
+func addFilesToZip(archivePath string, filePaths []string, opts archiveOptions) (err error) {
+	dir := filepath.Dir(archivePath)
+	if err = opts.perms.mkdirAll(opts.fs, dir); err != nil {
+		return err
+	}
+
+	tmp, err := opts.perms.createTemp(opts.fs, dir, filepath.Base(archivePath)+".", rollingTemporaryFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			opts.fs.Remove(tmp.Name())
+		}
+	}()
+
+	zw := zip.NewWriter(tmp)
+
+	// Copy the entries of the existing archive without recompressing them.
+	names := make(map[string]bool)
+	archive, err := opts.fs.OpenFile(archivePath, os.O_RDONLY, 0)
+	if err == nil {
+		err = copyZipEntries(zw, archive, names)
+		archive.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, filePath := range filePaths {
+		if err = addFileToZip(opts.fs, zw, filePath, names); err != nil {
+			return err
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = closeArchive(tmp, opts.durable); err != nil {
+		return err
+	}
+	if err = opts.fs.Rename(tmp.Name(), archivePath); err != nil {
+		return err
+	}
+	if opts.durable {
+		return syncDir(opts.fs, dir)
+	}
+	return nil
+}
+
+// copyZipEntries copies the entries of the zip archive file, collecting their names.
+func copyZipEntries(zw *zip.Writer, archive File, names map[string]bool) error {
+	stat, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(archive, stat.Size())
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if err = zw.Copy(f); err != nil {
+			return err
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
+
+// uniqueZipName returns the name, suffixed if needed to differ from the taken names.
+func uniqueZipName(name string, names map[string]bool) string {
+	unique := name
+	for i := 2; names[unique]; i++ {
+		unique = name + "~" + strconv.Itoa(i)
+	}
+	return unique
+}
+
+// closeArchive closes a finished temporary archive file, syncing it first if durable is set.
+func closeArchive(f File, durable bool) error {
+	if durable {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func addFileToZip(fs FileSystem, zw *zip.Writer, filePath string, names map[string]bool) error {
+	f, err := fs.OpenFile(filePath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(stat)
+	if err != nil {
+		return err
+	}
+	header.Name = uniqueZipName(filepath.Base(filePath), names)
+	names[header.Name] = true
+	header.Method = zip.Deflate
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// gzipFile compresses the file at filePath into archivePath and removes the
+// original. The compressed data is written to a temporary file first, so the
+// archive either exists completely or not at all.
+func gzipFile(filePath, archivePath string, opts archiveOptions) (err error) {
+	f, err := opts.fs.OpenFile(filePath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := archivePath + rollingTemporaryFileSuffix
+	tmp, err := opts.perms.createFile(opts.fs, tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			opts.fs.Remove(tmpPath)
+		}
+	}()
+
+	gw := gzip.NewWriter(tmp)
+	gw.Name = filepath.Base(filePath)
+	gw.ModTime = stat.ModTime()
+	if _, err = io.Copy(gw, f); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	if err = closeArchive(tmp, opts.durable); err != nil {
+		return err
+	}
+	if err = opts.fs.Rename(tmpPath, archivePath); err != nil {
+		return err
+	}
+	if opts.durable {
+		if err = syncDir(opts.fs, filepath.Dir(archivePath)); err != nil {
+			return err
+		}
+	}
+
+	f.Close()
+	return tryRemoveFile(opts.fs, filePath)
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Policies of AsyncWriter for writes to a full queue.
+type OverflowPolicy uint8
+
+const (
+	OverflowBlock      = iota // Write waits until there is room in the queue
+	OverflowDropNewest        // The written data is dropped
+	OverflowDropOldest        // The oldest queued data is dropped to make room
+)
+
+var OverflowPoliciesStringRepresentation = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropNewest: "dropnewest",
+	OverflowDropOldest: "dropoldest",
+}
+
+func OverflowPolicyFromString(overflowPolicyStr string) (OverflowPolicy, bool) {
+	for tp, tpStr := range OverflowPoliciesStringRepresentation {
+		if tpStr == overflowPolicyStr {
+			return tp, true
+		}
+	}
+
+	return 0, false
+}
+
+// AsyncWriterStats are the counters of an AsyncWriter.
+type AsyncWriterStats struct {
+	Queued        int    // Writes waiting in the queue
+	Written       uint64 // Writes passed to the inner writer
+	DroppedNewest uint64 // Writes dropped by OverflowDropNewest
+	DroppedOldest uint64 // Queued writes dropped by OverflowDropOldest
+	WriteErrors   uint64 // Writes failed by the inner writer
+}
+
+// Dropped returns the total number of dropped writes.
+func (stats AsyncWriterStats) Dropped() uint64 {
+	return stats.DroppedNewest + stats.DroppedOldest
+}
+
+// AsyncWriter queues writes into a bounded in-memory ring and writes them to the
+// inner writer on a separate goroutine, so the callers never wait for the inner
+// writer unless the queue is full and the policy is OverflowBlock.
+//
+// Errors of the inner writer are passed to ErrorHandler, if it is set.
+// Close writes out all the queued data and closes the inner writer.
+type AsyncWriter struct {
+	// ErrorHandler is called on the writing goroutine with the writer unlocked,
+	// so it may use Write and Stats. It must not wait for the goroutine: Flush,
+	// Close and a Write blocked by OverflowBlock would never return.
+	ErrorHandler func(error)
+
+	innerWriter io.Writer
+	policy      OverflowPolicy
+	mutex       *sync.Mutex
+	changed     *sync.Cond // Signaled when the queue or the writing state changes
+	ring        [][]byte
+	head        int  // Index of the oldest queued write
+	count       int  // Number of queued writes
+	writing     bool // The goroutine is writing data taken from the queue
+	closed      bool
+	done        chan struct{}
+	stats       AsyncWriterStats
+}
+
+// NewAsyncWriter creates a new async writer with room for queueSize writes.
+func NewAsyncWriter(innerWriter io.Writer, queueSize int, policy OverflowPolicy) (*AsyncWriter, error) {
+	if innerWriter == nil {
+		return nil, errors.New("argument is nil: innerWriter")
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("queueSize can not be less or equal to 0. Got: %d", queueSize)
+	}
+	if _, ok := OverflowPoliciesStringRepresentation[policy]; !ok {
+		return nil, fmt.Errorf("unknown overflow policy: %d", policy)
+	}
+
+	newWriter := new(AsyncWriter)
+	newWriter.innerWriter = innerWriter
+	newWriter.policy = policy
+	newWriter.mutex = new(sync.Mutex)
+	newWriter.changed = sync.NewCond(newWriter.mutex)
+	newWriter.ring = make([][]byte, queueSize)
+	newWriter.done = make(chan struct{})
+
+	go newWriter.run()
+
+	return newWriter, nil
+}
+
+// Write queues a copy of the data. It always reports the full length as written
+// unless the writer is closed: dropped data is only accounted in the stats.
+func (asyncWriter *AsyncWriter) Write(bytes []byte) (n int, err error) {
+	asyncWriter.mutex.Lock()
+	defer asyncWriter.mutex.Unlock()
+
+	if asyncWriter.closed {
+		return 0, ErrWriterClosed
+	}
+
+	for asyncWriter.count == len(asyncWriter.ring) {
+		switch asyncWriter.policy {
+		case OverflowDropNewest:
+			asyncWriter.stats.DroppedNewest++
+			return len(bytes), nil
+		case OverflowDropOldest:
+			asyncWriter.pop()
+			asyncWriter.stats.DroppedOldest++
+		default:
+			asyncWriter.changed.Wait()
+			if asyncWriter.closed {
+				return 0, ErrWriterClosed
+			}
+		}
+	}
+
+	data := make([]byte, len(bytes))
+	copy(data, bytes)
+	asyncWriter.ring[(asyncWriter.head+asyncWriter.count)%len(asyncWriter.ring)] = data
+	asyncWriter.count++
+	asyncWriter.changed.Broadcast()
+
+	return len(bytes), nil
+}
+
+// pop removes the oldest write from the queue and returns it.
+func (asyncWriter *AsyncWriter) pop() []byte {
+	data := asyncWriter.ring[asyncWriter.head]
+	asyncWriter.ring[asyncWriter.head] = nil
+	asyncWriter.head = (asyncWriter.head + 1) % len(asyncWriter.ring)
+	asyncWriter.count--
+	return data
+}
+
+func (asyncWriter *AsyncWriter) run() {
+	defer close(asyncWriter.done)
+
+	asyncWriter.mutex.Lock()
+	defer asyncWriter.mutex.Unlock()
+
+	for {
+		for asyncWriter.count == 0 && !asyncWriter.closed {
+			asyncWriter.changed.Wait()
+		}
+		if asyncWriter.count == 0 {
+			return
+		}
+
+		data := asyncWriter.pop()
+		asyncWriter.writing = true
+		asyncWriter.changed.Broadcast()
+		asyncWriter.mutex.Unlock()
+
+		_, err := asyncWriter.innerWriter.Write(data)
+
+		asyncWriter.mutex.Lock()
+		asyncWriter.stats.Written++
+		if err != nil {
+			asyncWriter.stats.WriteErrors++
+			if asyncWriter.ErrorHandler != nil {
+				// Flush still waits for the handler, as the write is not done yet.
+				asyncWriter.mutex.Unlock()
+				asyncWriter.ErrorHandler(err)
+				asyncWriter.mutex.Lock()
+			}
+		}
+		asyncWriter.writing = false
+		asyncWriter.changed.Broadcast()
+	}
+}
+
+// Flush waits until all the data queued so far is written to the inner writer.
+func (asyncWriter *AsyncWriter) Flush() {
+	asyncWriter.mutex.Lock()
+	defer asyncWriter.mutex.Unlock()
+
+	for asyncWriter.count != 0 || asyncWriter.writing {
+		asyncWriter.changed.Wait()
+	}
+}
+
+// Stats returns the current counters of the writer.
+func (asyncWriter *AsyncWriter) Stats() AsyncWriterStats {
+	asyncWriter.mutex.Lock()
+	defer asyncWriter.mutex.Unlock()
+
+	stats := asyncWriter.stats
+	stats.Queued = asyncWriter.count
+	return stats
+}
+
+// Close writes out the queued data, stops the goroutine and closes the inner
+// writer if it is an io.Closer. Blocked writes return ErrWriterClosed.
+func (asyncWriter *AsyncWriter) Close() error {
+	asyncWriter.mutex.Lock()
+	if asyncWriter.closed {
+		asyncWriter.mutex.Unlock()
+		return ErrWriterClosed
+	}
+	asyncWriter.closed = true
+	asyncWriter.changed.Broadcast()
+	asyncWriter.mutex.Unlock()
+
+	<-asyncWriter.done
+
+	closer, ok := asyncWriter.innerWriter.(io.Closer)
+	if ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (asyncWriter *AsyncWriter) String() string {
+	return fmt.Sprintf("AsyncWriter queue size: %d, policy: %s", len(asyncWriter.ring), OverflowPoliciesStringRepresentation[asyncWriter.policy])
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedWriter records writes, which wait until the gate is opened.
+type gatedWriter struct {
+	gate    chan struct{}
+	started chan struct{} // receives a value when a write starts
+	mutex   sync.Mutex
+	writes  []string
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{gate: make(chan struct{}), started: make(chan struct{}, 1)}
+}
+
+func (writer *gatedWriter) Write(bytes []byte) (n int, err error) {
+	select {
+	case writer.started <- struct{}{}:
+	default:
+	}
+	<-writer.gate
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.writes = append(writer.writes, string(bytes))
+	return len(bytes), nil
+}
+
+func (writer *gatedWriter) getWrites() []string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.writes
+}
+
+// newStuckAsyncWriter returns an async writer with queue size 2, whose goroutine
+// is stuck writing "1".
+func newStuckAsyncWriter(t *testing.T, policy OverflowPolicy) (*AsyncWriter, *gatedWriter) {
+	inner := newGatedWriter()
+	writer, err := NewAsyncWriter(inner, 2, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte("1"))
+	<-inner.started
+	return writer, inner
+}
+
+func TestAsyncWriterDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy   OverflowPolicy
+		expected []string
+		stats    AsyncWriterStats
+	}{
+		{OverflowDropNewest, []string{"1", "2", "3"}, AsyncWriterStats{Written: 3, DroppedNewest: 2}},
+		{OverflowDropOldest, []string{"1", "4", "5"}, AsyncWriterStats{Written: 3, DroppedOldest: 2}},
+	}
+
+	for _, test := range tests {
+		writer, inner := newStuckAsyncWriter(t, test.policy)
+		for _, data := range []string{"2", "3", "4", "5"} {
+			if n, err := writer.Write([]byte(data)); n != len(data) || err != nil {
+				t.Errorf("%s: unexpected write result: %d, %v", writer, n, err)
+			}
+		}
+		if stats := writer.Stats(); stats.Queued != 2 || stats.Dropped() != 2 {
+			t.Errorf("%s: unexpected stats of the full queue: %+v", writer, stats)
+		}
+
+		close(inner.gate)
+		writer.Flush()
+		if !reflect.DeepEqual(inner.getWrites(), test.expected) {
+			t.Errorf("%s: expected writes %v. Got: %v", writer, test.expected, inner.getWrites())
+		}
+		if stats := writer.Stats(); stats != test.stats {
+			t.Errorf("%s: expected stats %+v. Got: %+v", writer, test.stats, stats)
+		}
+		writer.Close()
+	}
+}
+
+func TestAsyncWriterBlock(t *testing.T) {
+	writer, inner := newStuckAsyncWriter(t, OverflowBlock)
+	defer writer.Close()
+
+	writer.Write([]byte("2"))
+	writer.Write([]byte("3"))
+
+	written := make(chan struct{})
+	go func() {
+		writer.Write([]byte("4"))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatalf("write to a full queue is not blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(inner.gate)
+	<-written
+	writer.Flush()
+	if expected := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(inner.getWrites(), expected) {
+		t.Errorf("expected writes %v. Got: %v", expected, inner.getWrites())
+	}
+}
+
+func TestAsyncWriterClose(t *testing.T) {
+	writer, inner := newStuckAsyncWriter(t, OverflowBlock)
+	writer.Write([]byte("2"))
+	writer.Write([]byte("3"))
+
+	blocked := make(chan error)
+	go func() {
+		_, err := writer.Write([]byte("4"))
+		blocked <- err
+	}()
+
+	closed := make(chan error)
+	go func() {
+		closed <- writer.Close()
+	}()
+
+	// The blocked write is released by Close.
+	if err := <-blocked; !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("expected ErrWriterClosed for the blocked write. Got: %v", err)
+	}
+
+	// Queued data is written out before Close returns.
+	close(inner.gate)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1", "2", "3"}; !reflect.DeepEqual(inner.getWrites(), expected) {
+		t.Errorf("expected writes %v. Got: %v", expected, inner.getWrites())
+	}
+
+	if _, err := writer.Write([]byte("5")); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("expected ErrWriterClosed on write after close. Got: %v", err)
+	}
+}
+
+func TestAsyncWriterErrorHandler(t *testing.T) {
+	writer, err := NewAsyncWriter(&flakyWriter{fail: true}, 2, OverflowDropNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	// The handler may use the writer.
+	var handled []AsyncWriterStats
+	writer.ErrorHandler = func(err error) {
+		if !errors.Is(err, errTestWrite) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		handled = append(handled, writer.Stats())
+		if len(handled) == 1 {
+			writer.Write([]byte("2"))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		writer.Write([]byte("1"))
+		writer.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("error handler using the writer is deadlocked")
+	}
+
+	expected := []AsyncWriterStats{{Written: 1, WriteErrors: 1}, {Written: 2, WriteErrors: 2}}
+	if !reflect.DeepEqual(handled, expected) {
+		t.Errorf("expected stats in the handler %+v. Got: %+v", expected, handled)
+	}
+}
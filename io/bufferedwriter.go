@@ -25,7 +25,8 @@
 package io
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -33,19 +34,67 @@ import (
 	"time"
 )
 
-// BufferedWriter stores data in memory and flushes it every flushPeriod or when buffer is full
+// ErrWriterClosed is returned by the writes to a closed writer.
+var ErrWriterClosed = errors.New("writer is closed")
+
+// BufferedWriterStats are the counters of a BufferedWriter.
+type BufferedWriterStats struct {
+	Buffered       int    // Bytes currently in the buffer
+	BytesWritten   uint64 // Bytes accepted by Write
+	BytesFlushed   uint64 // Bytes passed to the inner writer, including big chunks
+	BytesDiscarded uint64 // Buffered bytes dropped by Reset or by a failed flush on Close
+	Flushes        uint64 // Successful flushes of the buffer
+	BigChunkWrites uint64 // Writes bigger than the buffer passed directly to the inner writer
+	Errors         uint64 // Failed writes to the inner writer
+}
+
+// BufferedWriter stores data in memory and flushes it every flushPeriod or when buffer is full.
+// Close flushes the buffer, stops the periodic flushes and closes the inner writer.
+//
+// A failed flush keeps the data that was not written in the buffer, so it is
+// retried by the next flush. Until then writes that do not fit into the buffer
+// return the error. Errors of the inner writer, including the ones of periodic
+// flushes, are passed to ErrorHandler, if it is set, and kept for LastError.
+//
+// If RecordDelimiter is set, the buffer is flushed only up to the end of the last
+// complete record, so the inner writer receives whole records. Only the records
+// that do not fit into the buffer are written in parts, and Close writes out the
+// unfinished record.
 type BufferedWriter struct {
+	// ErrorHandler is called with the buffer locked, so it must not use the writer.
+	ErrorHandler func(error)
+
+	// RecordDelimiter ends every record, e.g. "\n" for lines. No framing if empty.
+	RecordDelimiter []byte
+
 	flushPeriod       time.Duration // data flushes interval (in microseconds)
 	bufferMutex       *sync.Mutex   // mutex for buffer operations syncronization
 	innerWriter       io.Writer     // inner writer
-	buffer            *bufio.Writer // buffered wrapper for inner writer
+	buffer            []byte        // buffered data, its capacity is bufferSizeInBytes
 	bufferSizeInBytes int           // max size of data chunk in bytes
+	closed            bool          // set by Close, guarded by bufferMutex
+	stop              chan struct{} // closed by Close to stop the flushing goroutine
+	done              chan struct{} // closed when the flushing goroutine exits
+	lastError         error
+	stats             BufferedWriterStats
 }
 
 // NewBufferedWriter creates a new buffered writer struct.
 // bufferSizeInBytes -- size of memory buffer in bytes
 // flushPeriod -- period in which data flushes from memory buffer in milliseconds. 0 - turn off this functionality
 func NewBufferedWriter(innerWriter io.Writer, bufferSizeInBytes int, flushPeriod time.Duration) (*BufferedWriter, error) {
+	return NewBufferedWriterContext(context.Background(), innerWriter, bufferSizeInBytes, flushPeriod)
+}
+
+// NewBufferedWriterContext creates a new buffered writer, which is closed when
+// the context is done.
+func NewBufferedWriterContext(ctx context.Context, innerWriter io.Writer, bufferSizeInBytes int, flushPeriod time.Duration) (*BufferedWriter, error) {
+	return NewBufferedWriterClock(ctx, innerWriter, bufferSizeInBytes, flushPeriod, defaultClock)
+}
+
+// NewBufferedWriterClock creates a new buffered writer, which is closed when the
+// context is done and takes the flush ticker from the clock.
+func NewBufferedWriterClock(ctx context.Context, innerWriter io.Writer, bufferSizeInBytes int, flushPeriod time.Duration, clock Clock) (*BufferedWriter, error) {
 
 	if innerWriter == nil {
 		return nil, errors.New("argument is nil: innerWriter")
@@ -57,38 +106,48 @@ func NewBufferedWriter(innerWriter io.Writer, bufferSizeInBytes int, flushPeriod
 	if bufferSizeInBytes <= 0 {
 		return nil, fmt.Errorf("bufferSizeInBytes can not be less or equal to 0. Got: %d", bufferSizeInBytes)
 	}
-
-	buffer := bufio.NewWriterSize(innerWriter, bufferSizeInBytes)
-
-	/*if err != nil {
-		return nil, err
-	}*/
+	if clock == nil {
+		return nil, errors.New("argument is nil: clock")
+	}
 
 	newWriter := new(BufferedWriter)
 
 	newWriter.innerWriter = innerWriter
-	newWriter.buffer = buffer
+	newWriter.buffer = make([]byte, 0, bufferSizeInBytes)
 	newWriter.bufferSizeInBytes = bufferSizeInBytes
 	newWriter.flushPeriod = flushPeriod * 1e6
 	newWriter.bufferMutex = new(sync.Mutex)
-
-	if flushPeriod != 0 {
-		go newWriter.flushPeriodically()
+	newWriter.stop = make(chan struct{})
+	newWriter.done = make(chan struct{})
+
+	if flushPeriod != 0 || ctx.Done() != nil {
+		// The ticker is created here, so the clock can be moved right after the return.
+		var ticker Ticker
+		if flushPeriod != 0 {
+			ticker = clock.NewTicker(newWriter.flushPeriod)
+		}
+		go newWriter.flushPeriodically(ctx, ticker)
+	} else {
+		close(newWriter.done)
 	}
 
 	return newWriter, nil
 }
 
 func (bufWriter *BufferedWriter) writeBigChunk(bytes []byte) (n int, err error) {
-	bufferedLen := bufWriter.buffer.Buffered()
-
-	n, err = bufWriter.flushInner()
+	err = bufWriter.flushInner(len(bufWriter.buffer))
 	if err != nil {
-		return
+		return 0, err
 	}
 
-	written, writeErr := bufWriter.innerWriter.Write(bytes)
-	return bufferedLen + written, writeErr
+	bufWriter.stats.BigChunkWrites++
+	n, err = bufWriter.innerWriter.Write(bytes)
+	bufWriter.stats.BytesWritten += uint64(n)
+	bufWriter.stats.BytesFlushed += uint64(n)
+	if err != nil {
+		bufWriter.reportError(err)
+	}
+	return n, err
 }
 
 // Sends data to buffer manager. Waits until all buffers are full.
@@ -97,61 +156,259 @@ func (bufWriter *BufferedWriter) Write(bytes []byte) (n int, err error) {
 	bufWriter.bufferMutex.Lock()
 	defer bufWriter.bufferMutex.Unlock()
 
+	if bufWriter.closed {
+		return 0, ErrWriterClosed
+	}
+
 	bytesLen := len(bytes)
 
+	if len(bufWriter.RecordDelimiter) != 0 {
+		return bufWriter.writeRecords(bytes)
+	}
+
 	if bytesLen > bufWriter.bufferSizeInBytes {
 		return bufWriter.writeBigChunk(bytes)
 	}
 
-	if bytesLen > bufWriter.buffer.Available() {
-		n, err = bufWriter.flushInner()
+	if bytesLen > cap(bufWriter.buffer)-len(bufWriter.buffer) {
+		err = bufWriter.flushInner(len(bufWriter.buffer))
 		if err != nil {
-			return
+			return 0, err
 		}
 	}
 
-	bufWriter.buffer.Write(bytes)
+	bufWriter.buffer = append(bufWriter.buffer, bytes...)
+	bufWriter.stats.BytesWritten += uint64(bytesLen)
 
-	return len(bytes), nil
+	return bytesLen, nil
 }
 
+// writeRecords buffers the data in the record framing mode.
+func (bufWriter *BufferedWriter) writeRecords(data []byte) (n int, err error) {
+	if len(data) > cap(bufWriter.buffer)-len(bufWriter.buffer) {
+		err = bufWriter.flushInner(bufWriter.recordsLen(bufWriter.buffer))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(data) <= cap(bufWriter.buffer)-len(bufWriter.buffer) {
+		bufWriter.buffer = append(bufWriter.buffer, data...)
+		bufWriter.stats.BytesWritten += uint64(len(data))
+		return len(data), nil
+	}
+
+	// The unfinished record does not fit into the buffer, so it is written out
+	// along with the data up to the last record end. The rest of the data is
+	// kept, unless it does not fit either.
+	bufferedLen := len(bufWriter.buffer)
+	pending := append(bufWriter.buffer[:bufferedLen:bufferedLen], data...)
+	end := bufWriter.recordsLen(pending)
+	if end <= bufferedLen || len(pending)-end > cap(bufWriter.buffer) {
+		end = len(pending)
+	}
+
+	bufWriter.stats.BigChunkWrites++
+	written, err := bufWriter.innerWriter.Write(pending[:end])
+	if written > end {
+		written = end
+	}
+	bufWriter.stats.BytesFlushed += uint64(written)
+	if err != nil {
+		bufWriter.reportError(err)
+		if written <= bufferedLen {
+			bufWriter.buffer = bufWriter.buffer[:copy(bufWriter.buffer, bufWriter.buffer[written:])]
+			return 0, err
+		}
+		bufWriter.buffer = bufWriter.buffer[:0]
+		bufWriter.stats.BytesWritten += uint64(written - bufferedLen)
+		return written - bufferedLen, err
+	}
+
+	bufWriter.buffer = append(bufWriter.buffer[:0], pending[end:]...)
+	bufWriter.stats.BytesWritten += uint64(len(data))
+	return len(data), nil
+}
+
+// recordsLen returns the length of the complete records at the start of the
+// data. Without framing all the data counts.
+func (bufWriter *BufferedWriter) recordsLen(data []byte) int {
+	if len(bufWriter.RecordDelimiter) == 0 {
+		return len(data)
+	}
+	i := bytes.LastIndex(data, bufWriter.RecordDelimiter)
+	if i < 0 {
+		return 0
+	}
+	return i + len(bufWriter.RecordDelimiter)
+}
+
+// Close flushes the buffer, stops the periodic flushes and closes the inner writer
+// if it is an io.Closer. Returns ErrWriterClosed if the writer is already closed.
 func (bufWriter *BufferedWriter) Close() error {
+	return bufWriter.close(true)
+}
+
+// close closes the writer. The flushing goroutine calls it with wait unset, as
+// it can not wait for itself.
+func (bufWriter *BufferedWriter) close(wait bool) error {
+	bufWriter.bufferMutex.Lock()
+	if bufWriter.closed {
+		bufWriter.bufferMutex.Unlock()
+		return ErrWriterClosed
+	}
+	bufWriter.closed = true
+	err := bufWriter.flushInner(len(bufWriter.buffer))
+	// Nothing is going to retry the flush.
+	bufWriter.discardBuffer()
+	bufWriter.bufferMutex.Unlock()
+
+	// The goroutine may be waiting for the mutex, so it is released first.
+	close(bufWriter.stop)
+	if wait {
+		<-bufWriter.done
+	}
+
 	closer, ok := bufWriter.innerWriter.(io.Closer)
 	if ok {
-		return closer.Close()
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
 	}
 
-	return nil
+	return err
+}
+
+// Flush writes the buffered data to the inner writer. With record framing the
+// unfinished record stays in the buffer.
+func (bufWriter *BufferedWriter) Flush() error {
+
+	bufWriter.bufferMutex.Lock()
+	defer bufWriter.bufferMutex.Unlock()
+
+	if bufWriter.closed {
+		return nil
+	}
+	return bufWriter.flushInner(bufWriter.recordsLen(bufWriter.buffer))
+}
+
+// Reset drops the buffered data and forgets the last error. It lets a writer
+// whose inner writer keeps failing start over.
+func (bufWriter *BufferedWriter) Reset() {
+	bufWriter.bufferMutex.Lock()
+	defer bufWriter.bufferMutex.Unlock()
+
+	bufWriter.discardBuffer()
+	bufWriter.lastError = nil
 }
 
-func (bufWriter *BufferedWriter) Flush() {
+// LastError returns the last error of the inner writer, nil if there was none
+// since the writer was created or reset.
+func (bufWriter *BufferedWriter) LastError() error {
+	bufWriter.bufferMutex.Lock()
+	defer bufWriter.bufferMutex.Unlock()
+
+	return bufWriter.lastError
+}
 
+// Stats returns the current counters of the writer.
+func (bufWriter *BufferedWriter) Stats() BufferedWriterStats {
 	bufWriter.bufferMutex.Lock()
 	defer bufWriter.bufferMutex.Unlock()
 
-	bufWriter.flushInner()
+	stats := bufWriter.stats
+	stats.Buffered = len(bufWriter.buffer)
+	return stats
 }
 
-func (bufWriter *BufferedWriter) flushInner() (n int, err error) {
-	bufferedLen := bufWriter.buffer.Buffered()
-	flushErr := bufWriter.buffer.Flush()
+// Sync flushes the buffer and, if the inner writer can sync (like file writers
+// do), commits the data to the disk. Otherwise flushed data ends up in the OS
+// cache only, or is synced according to the durability policy of the inner writer.
+func (bufWriter *BufferedWriter) Sync() error {
+	bufWriter.bufferMutex.Lock()
+	defer bufWriter.bufferMutex.Unlock()
 
-	return bufWriter.buffer.Buffered() - bufferedLen, flushErr
+	if bufWriter.closed {
+		return ErrWriterClosed
+	}
+	if err := bufWriter.flushInner(bufWriter.recordsLen(bufWriter.buffer)); err != nil {
+		return err
+	}
+	if syncer, ok := bufWriter.innerWriter.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// flushInner writes the first size bytes of the buffer to the inner writer. The
+// data that was not written stays in the buffer.
+func (bufWriter *BufferedWriter) flushInner(size int) error {
+	if size == 0 {
+		return nil
+	}
+
+	n, err := bufWriter.innerWriter.Write(bufWriter.buffer[:size])
+	if n > size {
+		n = size
+	}
+	if n > 0 {
+		bufWriter.stats.BytesFlushed += uint64(n)
+		bufWriter.buffer = bufWriter.buffer[:copy(bufWriter.buffer, bufWriter.buffer[n:])]
+	}
+	if err == nil && n < size {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		bufWriter.reportError(err)
+		return err
+	}
+
+	bufWriter.stats.Flushes++
+	return nil
+}
+
+func (bufWriter *BufferedWriter) discardBuffer() {
+	bufWriter.stats.BytesDiscarded += uint64(len(bufWriter.buffer))
+	bufWriter.buffer = bufWriter.buffer[:0]
+}
+
+func (bufWriter *BufferedWriter) reportError(err error) {
+	bufWriter.stats.Errors++
+	bufWriter.lastError = err
+	if bufWriter.ErrorHandler != nil {
+		bufWriter.ErrorHandler(err)
+	}
 }
 
 func (bufWriter *BufferedWriter) flushBuffer() {
 	bufWriter.bufferMutex.Lock()
 	defer bufWriter.bufferMutex.Unlock()
 
-	bufWriter.buffer.Flush()
+	if bufWriter.closed {
+		return
+	}
+	bufWriter.flushInner(bufWriter.recordsLen(bufWriter.buffer))
 }
 
-func (bufWriter *BufferedWriter) flushPeriodically() {
-	if bufWriter.flushPeriod > 0 {
-		ticker := time.NewTicker(bufWriter.flushPeriod)
-		for {
-			<-ticker.C
+// flushPeriodically flushes the buffer on every tick of the ticker, if it is
+// not nil, until the writer is closed, and closes the writer when the context is done.
+func (bufWriter *BufferedWriter) flushPeriodically(ctx context.Context, ticker Ticker) {
+	defer close(bufWriter.done)
+
+	var tick <-chan time.Time
+	if ticker != nil {
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	for {
+		select {
+		case <-tick:
 			bufWriter.flushBuffer()
+		case <-bufWriter.stop:
+			return
+		case <-ctx.Done():
+			bufWriter.close(false)
+			return
 		}
 	}
 }
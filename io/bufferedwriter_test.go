@@ -25,7 +25,11 @@
 package io
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestChunkWriteOnFilling(t *testing.T) {
@@ -51,11 +55,14 @@ func TestFlushByTimePeriod(t *testing.T) {
 		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
 	}
 
+	defer bufferedWriter.Close()
+
 	bytes := []byte("Hello")
 
 	for i := 0; i < 2; i++ {
 		writer.ExpectBytes(bytes)
 		bufferedWriter.Write(bytes)
+		writer.WaitForInput(time.Second)
 	}
 }
 
@@ -76,3 +83,202 @@ func TestBigMessageMustPassMemoryBuffer(t *testing.T) {
 	writer.ExpectBytes(bytes)
 	bufferedWriter.Write(bytes)
 }
+
+type closeCounter struct {
+	nullWriter
+	closed int
+}
+
+func (writer *closeCounter) Close() error {
+	writer.closed++
+	return nil
+}
+
+func TestBufferedWriterClose(t *testing.T) {
+	writer, _ := newBytesVerifier(t)
+	bufferedWriter, err := NewBufferedWriter(writer, 1024, 1000)
+
+	if err != nil {
+		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
+	}
+
+	bytes := []byte("Hello")
+	bufferedWriter.Write(bytes)
+
+	// Buffered data is not lost on Close.
+	writer.ExpectBytes(bytes)
+	if err := bufferedWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writer.MustNotExpect()
+
+	select {
+	case <-bufferedWriter.done:
+	default:
+		t.Errorf("flushing goroutine is not stopped")
+	}
+
+	if _, err := bufferedWriter.Write(bytes); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("expected ErrWriterClosed on write after close. Got: %v", err)
+	}
+	if err := bufferedWriter.Close(); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("expected ErrWriterClosed on second close. Got: %v", err)
+	}
+}
+
+func TestBufferedWriterClosesInnerWriter(t *testing.T) {
+	writer := new(closeCounter)
+	bufferedWriter, err := NewBufferedWriter(writer, 1024, 0)
+
+	if err != nil {
+		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
+	}
+
+	bufferedWriter.Close()
+	bufferedWriter.Close()
+	if writer.closed != 1 {
+		t.Errorf("expected the inner writer to be closed once. Got: %d", writer.closed)
+	}
+}
+
+func TestBufferedWriterContext(t *testing.T) {
+	writer, _ := newBytesVerifier(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	bufferedWriter, err := NewBufferedWriterContext(ctx, writer, 1024, 0)
+
+	if err != nil {
+		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
+	}
+
+	bytes := []byte("Hello")
+	bufferedWriter.Write(bytes)
+
+	writer.ExpectBytes(bytes)
+	cancel()
+	<-bufferedWriter.done
+	writer.MustNotExpect()
+
+	if _, err := bufferedWriter.Write(bytes); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("expected ErrWriterClosed on write after cancellation. Got: %v", err)
+	}
+}
+
+var errTestWrite = errors.New("test write error")
+
+// flakyWriter fails all writes while fail is set, writing only partial bytes of them.
+type flakyWriter struct {
+	fail    bool
+	partial int
+	data    []byte
+}
+
+func (writer *flakyWriter) Write(bytes []byte) (n int, err error) {
+	if writer.fail {
+		writer.data = append(writer.data, bytes[:writer.partial]...)
+		return writer.partial, errTestWrite
+	}
+	writer.data = append(writer.data, bytes...)
+	return len(bytes), nil
+}
+
+func TestBufferedWriterErrorRecovery(t *testing.T) {
+	writer := &flakyWriter{fail: true, partial: 2}
+	bufferedWriter, err := NewBufferedWriter(writer, 10, 0)
+
+	if err != nil {
+		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
+	}
+	defer bufferedWriter.Close()
+
+	var handled []error
+	bufferedWriter.ErrorHandler = func(err error) { handled = append(handled, err) }
+
+	bufferedWriter.Write([]byte("123456"))
+	if err := bufferedWriter.Flush(); !errors.Is(err, errTestWrite) {
+		t.Fatalf("expected flush error. Got: %v", err)
+	}
+	if !errors.Is(bufferedWriter.LastError(), errTestWrite) || len(handled) != 1 {
+		t.Errorf("flush error is not reported: %v, %v", bufferedWriter.LastError(), handled)
+	}
+
+	// Only the part that was not written is kept in the buffer, and the writes
+	// that do not fit report the error.
+	if n, err := bufferedWriter.Write([]byte("abcdefgh")); n != 0 || !errors.Is(err, errTestWrite) {
+		t.Errorf("expected failed write. Got: %d, %v", n, err)
+	}
+
+	writer.fail = false
+	if n, err := bufferedWriter.Write([]byte("abcdefgh")); n != 8 || err != nil {
+		t.Errorf("expected the writer to recover. Got: %d, %v", n, err)
+	}
+	if err := bufferedWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if string(writer.data) != "123456abcdefgh" {
+		t.Errorf("unexpected data: %q", writer.data)
+	}
+
+	expected := BufferedWriterStats{BytesWritten: 14, BytesFlushed: 14, Flushes: 1, Errors: 2}
+	if stats := bufferedWriter.Stats(); stats != expected {
+		t.Errorf("expected stats %+v. Got: %+v", expected, stats)
+	}
+}
+
+func TestBufferedWriterReset(t *testing.T) {
+	writer := &flakyWriter{fail: true}
+	bufferedWriter, err := NewBufferedWriter(writer, 10, 0)
+
+	if err != nil {
+		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
+	}
+	defer bufferedWriter.Close()
+
+	bufferedWriter.Write([]byte("123456"))
+	bufferedWriter.Flush()
+	bufferedWriter.Reset()
+
+	if bufferedWriter.LastError() != nil {
+		t.Errorf("expected the last error to be reset. Got: %v", bufferedWriter.LastError())
+	}
+	writer.fail = false
+	bufferedWriter.Write(make([]byte, 20))
+
+	expected := BufferedWriterStats{BytesWritten: 26, BytesFlushed: 20, BytesDiscarded: 6, BigChunkWrites: 1, Errors: 1}
+	if stats := bufferedWriter.Stats(); stats != expected {
+		t.Errorf("expected stats %+v. Got: %+v", expected, stats)
+	}
+}
+
+// writesRecorder keeps every write separately.
+type writesRecorder struct {
+	writes []string
+}
+
+func (writer *writesRecorder) Write(bytes []byte) (n int, err error) {
+	writer.writes = append(writer.writes, string(bytes))
+	return len(bytes), nil
+}
+
+func TestBufferedWriterRecordFraming(t *testing.T) {
+	writer := new(writesRecorder)
+	bufferedWriter, err := NewBufferedWriter(writer, 10, 0)
+
+	if err != nil {
+		t.Fatalf("Unexpected buffered writer creation error: %s", err.Error())
+	}
+	bufferedWriter.RecordDelimiter = []byte("\n")
+
+	bufferedWriter.Write([]byte("abc\nde"))
+	bufferedWriter.Flush()
+	bufferedWriter.Write([]byte("fghij\nk"))
+	bufferedWriter.Write([]byte("lmn\n"))
+	// Records bigger than the buffer are written directly.
+	bufferedWriter.Write([]byte("opqrstuvwxyz\n01"))
+	bufferedWriter.Write([]byte("234"))
+	bufferedWriter.Close()
+
+	expected := []string{"abc\n", "defghij\n", "klmn\n", "opqrstuvwxyz\n", "01234"}
+	if !reflect.DeepEqual(writer.writes, expected) {
+		t.Errorf("expected writes %q. Got: %q", expected, writer.writes)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	ticker := clock.NewTicker(time.Minute)
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("unexpected tick before the period")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case now := <-ticker.C():
+		if !now.Equal(clock.Now()) {
+			t.Errorf("expected tick at %v. Got: %v", clock.Now(), now)
+		}
+	default:
+		t.Fatal("expected a tick after the period")
+	}
+
+	// Skipped periods produce a single tick, the next one comes a period later.
+	clock.Advance(150 * time.Second)
+	<-ticker.C()
+	clock.Advance(29 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("unexpected tick for the skipped periods")
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-ticker.C():
+	default:
+		t.Fatal("expected a tick after the period")
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("unexpected tick of the stopped ticker")
+	default:
+	}
+}
+
+type chanWriter chan string
+
+func (writer chanWriter) Write(bytes []byte) (n int, err error) {
+	writer <- string(bytes)
+	return len(bytes), nil
+}
+
+func TestBufferedWriterFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	writer := make(chanWriter, 1)
+	bufferedWriter, err := NewBufferedWriterClock(context.Background(), writer, 1024, 10, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bufferedWriter.Close()
+
+	bufferedWriter.Write([]byte("Hello"))
+	clock.Advance(9 * time.Millisecond)
+	if stats := bufferedWriter.Stats(); stats.Buffered != 5 {
+		t.Fatalf("expected the data to stay in the buffer. Got: %+v", stats)
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case data := <-writer:
+		if data != "Hello" {
+			t.Errorf("expected Hello. Got: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a flush after the period")
+	}
+}
+
+func newFakeClockTimeWriter(t *testing.T, clock Clock, fs FileSystem, pattern string, interval RollingIntervalType, loc *time.Location) *RollingFileWriterTime {
+	writer, err := NewRollingFileWriterTime(filepath.Join("logs", "log.testlog"), RollingArchiveNone, "", 0, pattern, interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Clock = clock
+	writer.FileSystem = fs
+	writer.Location = loc
+	return writer
+}
+
+func checkMemFileData(t *testing.T, fs *MemFileSystem, path string, expected []byte) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("%s: expected %q. Got: %q", path, expected, data)
+	}
+}
+
+func TestRollingFileWriterTimeMidnight(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 23, 59, 59, 0, time.UTC))
+	fs := NewMemFileSystem()
+	writer := newFakeClockTimeWriter(t, clock, fs, "2006-01-02", RollingIntervalDaily, time.UTC)
+	defer writer.Close()
+
+	writeMessages(t, writer, 1)
+	clock.Advance(time.Second)
+	writeMessages(t, writer, 1)
+
+	checkMemDirFiles(t, fs, "logs", "log.testlog.2026-10-16", "log.testlog.2026-10-17")
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog.2026-10-16"), bytesFileTest)
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog.2026-10-17"), bytesFileTest)
+}
+
+func TestRollingFileWriterTimeDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database is not available:", err)
+	}
+
+	// The day of the switch to summer time is 23 hours long.
+	clock := NewFakeClock(time.Date(2026, time.March, 8, 0, 30, 0, 0, loc))
+	fs := NewMemFileSystem()
+	writer := newFakeClockTimeWriter(t, clock, fs, "2006-01-02", RollingIntervalDaily, loc)
+	writeMessages(t, writer, 1)
+	clock.Advance(23 * time.Hour)
+	writeMessages(t, writer, 1)
+	writer.Close()
+	checkMemDirFiles(t, fs, "logs", "log.testlog.2026-03-08", "log.testlog.2026-03-09")
+
+	// The hour repeated on the switch back goes to the same file.
+	clock = NewFakeClock(time.Date(2026, time.November, 1, 0, 30, 0, 0, loc))
+	fs = NewMemFileSystem()
+	writer = newFakeClockTimeWriter(t, clock, fs, "2006-01-02T15", RollingIntervalHourly, loc)
+	defer writer.Close()
+	for i := 0; i < 4; i++ {
+		writeMessages(t, writer, 1)
+		clock.Advance(time.Hour)
+	}
+	checkMemDirFiles(t, fs, "logs", "log.testlog.2026-11-01T00", "log.testlog.2026-11-01T01", "log.testlog.2026-11-01T02")
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog.2026-11-01T01"), bytes.Repeat(bytesFileTest, 2))
+}
+
+func TestRollingFileWriterMaxAgeFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	fs := NewMemFileSystem()
+	fs.Clock = clock
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 0)
+	writer.Clock = clock
+	writer.MaxAge = time.Hour
+	defer writer.Close()
+
+	writeMessages(t, writer, 3)
+	clock.Advance(time.Hour + time.Second)
+	writeMessages(t, writer, 2)
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.2")
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Fallback modes of the rolling writers: where writes go while the free disk
+// space stays below the watermark.
+type FallbackMode uint8
+
+const (
+	FallbackDrop      = iota // Writes are dropped
+	FallbackStderr           // Writes go to the standard error
+	FallbackDirectory        // Writes go to the file of the same name in DiskSpaceGuard.FallbackDirPath
+)
+
+var FallbackModesStringRepresentation = map[FallbackMode]string{
+	FallbackDrop:      "drop",
+	FallbackStderr:    "stderr",
+	FallbackDirectory: "directory",
+}
+
+func FallbackModeFromString(fallbackModeStr string) (FallbackMode, bool) {
+	for tp, tpStr := range FallbackModesStringRepresentation {
+		if tpStr == fallbackModeStr {
+			return tp, true
+		}
+	}
+
+	return 0, false
+}
+
+// Modes of a rolling writer guarded by a DiskSpaceGuard.
+type DiskSpaceMode uint8
+
+const (
+	DiskSpaceNormal = iota // Writes go to the current file
+	DiskSpaceLow           // Writes go to the fallback
+)
+
+var DiskSpaceModesStringRepresentation = map[DiskSpaceMode]string{
+	DiskSpaceNormal: "normal",
+	DiskSpaceLow:    "low",
+}
+
+// DiskSpaceStatus is passed to DiskSpaceGuard.OnModeChange.
+type DiskSpaceStatus struct {
+	Mode      DiskSpaceMode
+	FreeBytes int64 // Free space measured by the check
+	Pruned    int   // Rolls removed by the check. Lost unless archived with RollingArchiveZip
+	PruneErr  error // Error that stopped the pruning, if any
+}
+
+// DiskSpaceGuard keeps a rolling writer from filling up the volume of its files.
+//
+// When the free space in the directory of the current file drops below
+// MinFreeBytes, the oldest rolls are removed one by one, regardless of the
+// retention limits, until there is enough space again. With RollingArchiveZip
+// each roll is put into the archive before it is removed, which only frees
+// space if the archive is on another volume, see RollingFileWriter.ArchivePath.
+// With the other archive types the pruned rolls are lost. If there is still not
+// enough space when no rolls are left, or the pruning fails, e.g. because the
+// archive can not be rebuilt on the full volume, writes go to the fallback until
+// a later check finds enough space. The mode changes only between records, see
+// RollingFileWriter.RecordDelimiter. With ProcessLocking the rolls are pruned
+// under the exclusive lock.
+type DiskSpaceGuard struct {
+	MinFreeBytes    int64         // Watermark of the free space. No guard if 0
+	CheckInterval   time.Duration // Free space is checked at most once per CheckInterval. On every write if 0
+	Fallback        FallbackMode
+	FallbackDirPath string // Used by FallbackDirectory. Should be on another volume
+
+	// OnModeChange is called when the mode changes or rolls are pruned. It is
+	// called with the writer locked, so it must not use the writer.
+	OnModeChange func(DiskSpaceStatus)
+}
+
+func (guard DiskSpaceGuard) enabled() bool {
+	return guard.MinFreeBytes > 0
+}
+
+// DiskSpaceReporter is implemented by the file systems that know their free space.
+// A DiskSpaceGuard works only with such file systems.
+type DiskSpaceReporter interface {
+	// FreeSpace returns the number of bytes available to the process on the
+	// volume of the path.
+	FreeSpace(path string) (int64, error)
+}
+
+var errFreeSpaceNotSupported = errors.New("free disk space is not supported by the file system")
+
+// The standard error used by FallbackStderr.
+var fallbackStderr io.Writer = os.Stderr
+
+func (OSFileSystem) FreeSpace(path string) (int64, error) {
+	return freeSpace(path)
+}
+
+func (rw *RollingFileWriter) freeSpace() (int64, error) {
+	reporter, ok := rw.fs().(DiskSpaceReporter)
+	if !ok {
+		return 0, errFreeSpaceNotSupported
+	}
+	return reporter.FreeSpace(rw.CurrentDirPath)
+}
+
+// checkDiskSpace measures the free space, if CheckInterval has passed since the
+// last check, prunes the history if the space is short and switches the mode.
+func (rw *RollingFileWriter) checkDiskSpace() error {
+	now := rw.clock().Now()
+	if rw.diskSpaceChecked && now.Sub(rw.lastDiskSpaceCheck) < rw.DiskSpace.CheckInterval {
+		return nil
+	}
+	rw.diskSpaceChecked = true
+	rw.lastDiskSpaceCheck = now
+
+	free, err := rw.freeSpace()
+	if err != nil {
+		return err
+	}
+	pruned := 0
+	var pruneErr error
+	if free < rw.DiskSpace.MinFreeBytes {
+		// Other processes must not roll or prune the same files meanwhile.
+		if rw.ProcessLocking {
+			err = rw.lockProcesses(true)
+			if err != nil {
+				return err
+			}
+		}
+		// A failed pruning leaves the space short, so the fallback is used
+		// instead of failing the writes.
+		pruned, free, pruneErr = rw.pruneHistory(free)
+		if rw.ProcessLocking {
+			// The lock is not converted atomically: someone may have rolled in between.
+			err = rw.lockProcesses(false)
+			if err == nil {
+				err = rw.checkCurrentFile()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var mode DiskSpaceMode = DiskSpaceNormal
+	if free < rw.DiskSpace.MinFreeBytes {
+		mode = DiskSpaceLow
+	}
+	if mode == rw.diskSpaceMode && pruned == 0 {
+		return nil
+	}
+	if mode != rw.diskSpaceMode {
+		rw.diskSpaceMode = mode
+		if mode == DiskSpaceNormal {
+			err = rw.closeFallbackFile()
+		}
+	}
+	if rw.DiskSpace.OnModeChange != nil {
+		rw.DiskSpace.OnModeChange(DiskSpaceStatus{Mode: mode, FreeBytes: free, Pruned: pruned, PruneErr: pruneErr})
+	}
+	return err
+}
+
+// pruneHistory removes the oldest rolls until the free space reaches the
+// watermark. Returns the number of removed rolls and the free space after that.
+func (rw *RollingFileWriter) pruneHistory(free int64) (int, int64, error) {
+	// The roll worker must not archive or remove the same rolls meanwhile.
+	rw.historyMutex.Lock()
+	defer rw.historyMutex.Unlock()
+
+	history, err := rw.getSortedLogHistory(rw.currentHistoryFileName())
+	if err != nil {
+		return 0, free, err
+	}
+
+	pruned := 0
+	for _, historyName := range history {
+		if free >= rw.DiskSpace.MinFreeBytes {
+			break
+		}
+		err = rw.removeRolls([]string{filepath.Join(rw.historyDirPath(), historyName)})
+		if err != nil {
+			return pruned, free, err
+		}
+		pruned++
+		free, err = rw.freeSpace()
+		if err != nil {
+			return pruned, free, err
+		}
+	}
+	return pruned, free, nil
+}
+
+// writeFallback writes the data to the fallback of the low disk space mode.
+func (rw *RollingFileWriter) writeFallback(data []byte) (int, error) {
+	switch rw.DiskSpace.Fallback {
+	case FallbackStderr:
+		return fallbackStderr.Write(data)
+	case FallbackDirectory:
+		if rw.fallbackFile == nil {
+			err := rw.Permissions.mkdirAll(rw.fs(), rw.DiskSpace.FallbackDirPath)
+			if err != nil {
+				return 0, err
+			}
+			filePath := filepath.Join(rw.DiskSpace.FallbackDirPath, rw.FileName)
+			rw.fallbackFile, err = rw.Permissions.openFile(rw.fs(), filePath, os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return rw.fallbackFile.Write(data)
+	}
+	return len(data), nil
+}
+
+func (rw *RollingFileWriter) closeFallbackFile() error {
+	if rw.fallbackFile == nil {
+		return nil
+	}
+	err := rw.fallbackFile.Close()
+	rw.fallbackFile = nil
+	return err
+}
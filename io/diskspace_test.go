@@ -0,0 +1,291 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newGuardedWriter(t *testing.T, fs FileSystem, guard DiskSpaceGuard) (*RollingFileWriterSize, *[]DiskSpaceStatus) {
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 0)
+	statuses := new([]DiskSpaceStatus)
+	guard.OnModeChange = func(status DiskSpaceStatus) {
+		*statuses = append(*statuses, status)
+	}
+	writer.DiskSpace = guard
+	return writer, statuses
+}
+
+func TestDiskSpaceGuardPrune(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	writer, statuses := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30})
+	defer writer.Close()
+
+	// Rolls are pruned beyond the retention limits, so writes never hit the limit.
+	writeMessages(t, writer, 20)
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.7", "log.testlog.8", "log.testlog.9")
+	if len(*statuses) != 6 {
+		t.Fatalf("expected 6 prunings. Got: %+v", *statuses)
+	}
+	for _, status := range *statuses {
+		if status.Mode != DiskSpaceNormal || status.Pruned != 1 {
+			t.Errorf("unexpected status: %+v", status)
+		}
+	}
+}
+
+// volumeMemFileSystem reports the free space of a volume holding only the files in dir.
+type volumeMemFileSystem struct {
+	*MemFileSystem
+	dir      string
+	capacity int64
+}
+
+func (fs volumeMemFileSystem) FreeSpace(path string) (int64, error) {
+	infos, err := fs.ReadDir(fs.dir)
+	if err != nil {
+		return 0, err
+	}
+	free := fs.capacity
+	for _, info := range infos {
+		free -= info.Size()
+	}
+	return free, nil
+}
+
+func TestDiskSpaceGuardPruneZip(t *testing.T) {
+	fs := volumeMemFileSystem{MemFileSystem: NewMemFileSystem(), dir: "logs", capacity: 100}
+	writer, _ := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30})
+	writer.ArchiveType = RollingArchiveZip
+	writer.ArchivePath = filepath.Join("archive", "log.zip")
+	defer writer.Close()
+
+	// Pruned rolls are kept in the archive on the other volume.
+	writeMessages(t, writer, 20)
+	checkMemDirFiles(t, fs.MemFileSystem, "logs", "log.testlog", "log.testlog.7", "log.testlog.8", "log.testlog.9")
+
+	data, err := fs.ReadFile(writer.ArchivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	expected := []string{"log.testlog.1", "log.testlog.2", "log.testlog.3", "log.testlog.4", "log.testlog.5", "log.testlog.6"}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected archive entries %v. Got: %v", expected, names)
+	}
+}
+
+func TestDiskSpaceGuardPruneZipSameVolume(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	writer, statuses := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30, Fallback: FallbackDrop})
+	writer.ArchiveType = RollingArchiveZip
+	writer.ArchivePath = filepath.Join("logs", "log.zip")
+	defer writer.Close()
+
+	// The archive can not be rebuilt on the full volume, so the writes go to
+	// the fallback instead of failing.
+	writeMessages(t, writer, 20)
+	if len(*statuses) != 1 || (*statuses)[0].Mode != DiskSpaceLow || !errors.Is((*statuses)[0].PruneErr, errMemNoSpace) {
+		t.Errorf("unexpected mode changes: %+v", *statuses)
+	}
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.1", "log.testlog.2", "log.testlog.3")
+}
+
+func TestDiskSpaceGuardFallbackDrop(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	writer, statuses := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30, Fallback: FallbackDrop})
+	defer writer.Close()
+
+	writeMessages(t, writer, 1)
+	if err := fs.WriteFile("other", make([]byte, 70), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeMessages(t, writer, 2)
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog"), bytesFileTest)
+
+	if err := fs.Remove("other"); err != nil {
+		t.Fatal(err)
+	}
+	writeMessages(t, writer, 1)
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog"), bytes.Repeat(bytesFileTest, 2))
+
+	expected := []DiskSpaceStatus{{Mode: DiskSpaceLow, FreeBytes: 20}, {Mode: DiskSpaceNormal, FreeBytes: 90}}
+	if len(*statuses) != len(expected) || (*statuses)[0] != expected[0] || (*statuses)[1] != expected[1] {
+		t.Errorf("expected mode changes %+v. Got: %+v", expected, *statuses)
+	}
+}
+
+func TestDiskSpaceGuardFallbackStderr(t *testing.T) {
+	stderr := new(bytes.Buffer)
+	fallbackStderr = stderr
+	defer func() { fallbackStderr = os.Stderr }()
+
+	fs := NewMemFileSystem()
+	fs.Capacity = 50
+	writer, statuses := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 60, Fallback: FallbackStderr})
+	defer writer.Close()
+
+	writeMessages(t, writer, 2)
+	if !bytes.Equal(stderr.Bytes(), bytes.Repeat(bytesFileTest, 2)) {
+		t.Errorf("unexpected standard error: %q", stderr.Bytes())
+	}
+	if len(*statuses) != 1 || (*statuses)[0].Mode != DiskSpaceLow {
+		t.Errorf("unexpected mode changes: %+v", *statuses)
+	}
+}
+
+func TestDiskSpaceGuardFallbackDirectory(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	writer, _ := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 60, Fallback: FallbackDirectory, FallbackDirPath: "spare"})
+	defer writer.Close()
+
+	// Older rolls are pruned first, the current file is kept.
+	writeMessages(t, writer, 5)
+	if err := fs.WriteFile("other", make([]byte, 40), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeMessages(t, writer, 2)
+
+	checkMemDirFiles(t, fs, "logs", "log.testlog")
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog"), bytesFileTest)
+	checkMemFileData(t, fs, filepath.Join("spare", "log.testlog"), bytes.Repeat(bytesFileTest, 2))
+}
+
+func TestDiskSpaceGuardCheckInterval(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	writer, statuses := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30, CheckInterval: time.Minute})
+	writer.Clock = clock
+	defer writer.Close()
+
+	writeMessages(t, writer, 1)
+	if err := fs.WriteFile("other", make([]byte, 70), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeMessages(t, writer, 1)
+	if len(*statuses) != 0 {
+		t.Fatalf("unexpected check before the interval: %+v", *statuses)
+	}
+
+	clock.Advance(time.Minute)
+	writeMessages(t, writer, 1)
+	if len(*statuses) != 1 || (*statuses)[0].Mode != DiskSpaceLow {
+		t.Errorf("unexpected mode changes: %+v", *statuses)
+	}
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog"), bytes.Repeat(bytesFileTest, 2))
+}
+
+func TestDiskSpaceGuardRecordFraming(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	writer, _ := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30})
+	writer.RecordDelimiter = []byte("\n")
+	defer writer.Close()
+
+	writer.Write([]byte("abc"))
+	if err := fs.WriteFile("other", make([]byte, 70), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The unfinished record is completed in the current file.
+	writer.Write([]byte("def\n"))
+	writer.Write([]byte("ghi\n"))
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog"), []byte("abcdef\n"))
+}
+
+func TestDiskSpaceGuardNotSupported(t *testing.T) {
+	writer, _ := newGuardedWriter(t, struct{ FileSystem }{NewMemFileSystem()}, DiskSpaceGuard{MinFreeBytes: 1})
+	defer writer.Close()
+
+	if _, err := writer.Write(bytesFileTest); err != errFreeSpaceNotSupported {
+		t.Errorf("expected %v. Got: %v", errFreeSpaceNotSupported, err)
+	}
+}
+
+func TestOSFileSystemFreeSpace(t *testing.T) {
+	free, err := OSFileSystem{}.FreeSpace(".")
+	if err == errFreeSpaceNotSupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free <= 0 {
+		t.Errorf("expected some free space. Got: %d", free)
+	}
+}
+
+func TestDiskSpaceGuardPruneBackgroundRoll(t *testing.T) {
+	fs := volumeMemFileSystem{MemFileSystem: NewMemFileSystem(), dir: "logs", capacity: 100}
+	writer, _ := newGuardedWriter(t, fs, DiskSpaceGuard{MinFreeBytes: 30})
+	writer.ArchiveType = RollingArchiveZip
+	writer.ArchivePath = filepath.Join("archive", "log.zip")
+	writer.MaxRolls = 2
+	writer.BackgroundRoll = true
+	writer.BackgroundErrorHandler = func(err error) {
+		t.Error(err)
+	}
+
+	// The worker and the guard archive the rolls concurrently, none is lost.
+	const writes = 200
+	writeMessages(t, writer, writes)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(writer.ArchivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := fs.ReadDir("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := len(zr.File) + len(infos); files != writes/2 {
+		t.Errorf("expected %d files in the archive and the history. Got: %d", writes/2, files)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"time"
+)
+
+// Durability modes of the file writers: when written data is synced to the disk.
+type SyncMode uint8
+
+const (
+	SyncNever      = iota // Syncing is left to the OS
+	SyncBytes             // Sync after every SyncPolicy.Bytes written
+	SyncInterval          // Sync not later than SyncPolicy.Interval after a write
+	SyncEveryWrite        // Sync after every write
+)
+
+var SyncModesStringRepresentation = map[SyncMode]string{
+	SyncNever:      "never",
+	SyncBytes:      "bytes",
+	SyncInterval:   "interval",
+	SyncEveryWrite: "write",
+}
+
+func SyncModeFromString(syncModeStr string) (SyncMode, bool) {
+	for tp, tpStr := range SyncModesStringRepresentation {
+		if tpStr == syncModeStr {
+			return tp, true
+		}
+	}
+
+	return 0, false
+}
+
+// SyncPolicy describes how often a file writer syncs written data to the disk.
+// With any mode but SyncNever the file and its directory are also synced before
+// a roll and compressed rolls are synced before they replace the original ones,
+// so a renamed history file survives a power loss.
+type SyncPolicy struct {
+	Mode     SyncMode
+	Bytes    int64         // Used by SyncBytes
+	Interval time.Duration // Used by SyncInterval
+}
+
+func (policy SyncPolicy) durable() bool {
+	return policy.Mode != SyncNever
+}
+
+// fileSyncer syncs a file according to a sync policy. Its owner guards it with
+// the same mutex as the file.
+type fileSyncer struct {
+	unsynced int64       // Bytes written since the last sync
+	timer    *time.Timer // Pending sync of SyncInterval mode
+	err      error       // Error of a sync made by the timer, returned by the next call
+}
+
+// written is called after n bytes were written to the file. syncLater is called by
+// the timer of SyncInterval mode with the owner's mutex not held.
+func (syncer *fileSyncer) written(f File, n int, policy SyncPolicy, syncLater func()) error {
+	if err := syncer.takeError(); err != nil {
+		return err
+	}
+
+	syncer.unsynced += int64(n)
+	switch policy.Mode {
+	case SyncEveryWrite:
+		return syncer.sync(f)
+	case SyncBytes:
+		if syncer.unsynced >= policy.Bytes {
+			return syncer.sync(f)
+		}
+	case SyncInterval:
+		if syncer.timer == nil && syncer.unsynced > 0 {
+			syncer.timer = time.AfterFunc(policy.Interval, syncLater)
+		}
+	}
+	return nil
+}
+
+// sync syncs the file if anything was written to it since the last sync.
+func (syncer *fileSyncer) sync(f File) error {
+	if syncer.unsynced == 0 {
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	syncer.unsynced = 0
+	return nil
+}
+
+// timerFired is called by syncLater holding the owner's mutex. f is nil if the
+// file was closed in the meantime.
+func (syncer *fileSyncer) timerFired(f File) {
+	syncer.timer = nil
+	if f == nil {
+		return
+	}
+	if err := syncer.sync(f); err != nil && syncer.err == nil {
+		syncer.err = err
+	}
+}
+
+func (syncer *fileSyncer) takeError() error {
+	err := syncer.err
+	syncer.err = nil
+	return err
+}
+
+// finish is called before the file is closed: the pending timer sync is cancelled
+// and, unless the policy is SyncNever, the unsynced data is synced.
+func (syncer *fileSyncer) finish(f File, policy SyncPolicy) error {
+	if syncer.timer != nil {
+		syncer.timer.Stop()
+		syncer.timer = nil
+	}
+	err := syncer.takeError()
+	if policy.durable() {
+		// Synced even if nothing was written by this writer: the file may have
+		// been written by another process or a previous run.
+		if syncErr := f.Sync(); err == nil {
+			err = syncErr
+		}
+	}
+	syncer.unsynced = 0
+	return err
+}
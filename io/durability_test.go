@@ -0,0 +1,170 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileWriterDurability(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	tests := []struct {
+		policy   SyncPolicy
+		unsynced []int64 // Expected unsynced bytes after every write
+	}{
+		{SyncPolicy{}, []int64{10, 20, 30}},
+		{SyncPolicy{Mode: SyncEveryWrite}, []int64{0, 0, 0}},
+		{SyncPolicy{Mode: SyncBytes, Bytes: 25}, []int64{10, 20, 0}},
+		{SyncPolicy{Mode: SyncInterval, Interval: time.Hour}, []int64{10, 20, 30}},
+	}
+
+	for _, test := range tests {
+		writer, err := NewFileWriter("log.testlog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.SetDurability(test.policy)
+
+		for i, expected := range test.unsynced {
+			if _, err := writer.Write(bytesFileTest); err != nil {
+				t.Fatal(err)
+			}
+			if writer.syncer.unsynced != expected {
+				t.Errorf("%v, write %d: expected %d unsynced bytes. Got: %d", test.policy, i, expected, writer.syncer.unsynced)
+			}
+		}
+		if (test.policy.Mode == SyncInterval) != (writer.syncer.timer != nil) {
+			t.Errorf("%v: unexpected sync timer state", test.policy)
+		}
+
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if writer.syncer.timer != nil {
+			t.Errorf("%v: sync timer is not stopped on close", test.policy)
+		}
+	}
+}
+
+func TestFileWriterSyncInterval(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewFileWriter("log.testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.SetDurability(SyncPolicy{Mode: SyncInterval, Interval: 10 * time.Millisecond})
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		writer.mutex.Lock()
+		unsynced, timer := writer.syncer.unsynced, writer.syncer.timer
+		writer.mutex.Unlock()
+		if unsynced == 0 && timer == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("data was not synced in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRollingFileWriterDurability(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveGzip, "", 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Durability = SyncPolicy{Mode: SyncBytes, Bytes: 15}
+
+	for i := 0; i < 5; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The last write went to a new file after a roll.
+	if writer.syncer.unsynced != int64(messageLen) {
+		t.Errorf("expected %d unsynced bytes. Got: %d", messageLen, writer.syncer.unsynced)
+	}
+	if err := writer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if writer.syncer.unsynced != 0 {
+		t.Errorf("expected no unsynced bytes after Sync. Got: %d", writer.syncer.unsynced)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFiles(t, map[string]string{
+		"log.testlog":      string(bytesFileTest),
+		"log.testlog.1.gz": "*",
+		"log.testlog.2.gz": "*",
+	})
+}
+
+func TestBufferedWriterSync(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	fileWriter, err := NewFileWriter("log.testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := NewBufferedWriter(fileWriter, 1024, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile("log.testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytesFileTest) {
+		t.Errorf("expected the buffer to be flushed. Got: %q", data)
+	}
+}
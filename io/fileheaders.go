@@ -0,0 +1,118 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSummary describes the data written to a file for its footer. The counters
+// cover only the writes of the writer since it opened the file, not the data
+// written before or by other processes.
+type FileSummary struct {
+	Path     string
+	Start    time.Time // Time the writer opened the file
+	End      time.Time // Time of the roll
+	Records  int64     // Records written: ended records if RecordDelimiter is set, writes otherwise
+	Bytes    int64     // Bytes written, without the header
+	Checksum uint32    // CRC-32 (IEEE) of the written bytes
+}
+
+// add accounts the data written to the file.
+func (summary *FileSummary) add(data []byte, delimiter []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if len(delimiter) != 0 {
+		summary.Records += int64(bytes.Count(data, delimiter))
+	} else {
+		summary.Records++
+	}
+	summary.Bytes += int64(len(data))
+	summary.Checksum = crc32.Update(summary.Checksum, crc32.IEEETable, data)
+}
+
+// W3CDateFormat is the date format of the W3C extended log file directives.
+const W3CDateFormat = "2006-01-02 15:04:05"
+
+// W3CHeader returns a RollingFileWriter.Header function writing the directives
+// of the W3C extended log file format: the version, the start time in UTC and
+// the fields, e.g. W3CHeader("date", "time", "c-ip", "cs-method", "cs-uri").
+func W3CHeader(fields ...string) func(filePath string, start time.Time) []byte {
+	return func(filePath string, start time.Time) []byte {
+		return []byte(fmt.Sprintf("#Version: 1.0\n#Date: %s\n#Fields: %s\n",
+			start.UTC().Format(W3CDateFormat),
+			strings.Join(fields, " ")))
+	}
+}
+
+// W3CFooter is a RollingFileWriter.Footer function writing the summary as W3C
+// extended log file directives, which the parsers skip or report:
+//
+//	#End-Date: 2026-10-16 12:00:00
+//	#Records: 1200
+//	#Bytes: 150000
+//	#CRC32: 8a9136aa
+func W3CFooter(summary FileSummary) []byte {
+	return []byte(fmt.Sprintf("#End-Date: %s\n#Records: %d\n#Bytes: %d\n#CRC32: %08x\n",
+		summary.End.UTC().Format(W3CDateFormat),
+		summary.Records,
+		summary.Bytes,
+		summary.Checksum))
+}
+
+// writeHeader starts the new, empty current file with the header.
+func (rw *RollingFileWriter) writeHeader() error {
+	header := rw.Header(filepath.Join(rw.CurrentDirPath, rw.FileName), rw.currentFileStart)
+	if len(header) == 0 {
+		return nil
+	}
+	n, err := rw.CurrentFile.Write(header)
+	rw.CurrentFileSize += int64(n)
+	if err != nil {
+		return err
+	}
+	return rw.syncer.written(rw.CurrentFile, n, rw.Durability, rw.syncLater)
+}
+
+// writeFooter ends the current file with the footer before it is rolled.
+func (rw *RollingFileWriter) writeFooter() error {
+	summary := rw.summary
+	summary.Path = filepath.Join(rw.CurrentDirPath, rw.FileName)
+	summary.Start = rw.currentFileStart
+	summary.End = rw.clock().Now()
+	footer := rw.Footer(summary)
+	if len(footer) == 0 {
+		return nil
+	}
+	n, err := rw.CurrentFile.Write(footer)
+	rw.CurrentFileSize += int64(n)
+	return err
+}
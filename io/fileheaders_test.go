@@ -0,0 +1,90 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRollingFileWriterHeaderFooter(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	fs := NewMemFileSystem()
+	writer, err := NewRollingFileWriterSize(filepath.Join("logs", "log.testlog"), RollingArchiveNone, "", 120, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.FileSystem = fs
+	writer.Clock = clock
+	writer.RecordDelimiter = []byte("\n")
+	writer.Header = W3CHeader("date", "time", "cs-uri")
+	writer.Footer = W3CFooter
+	defer writer.Close()
+
+	records := []string{"2026-10-16 12:00:00 /a\n", "2026-10-16 12:00:01 /b\n", "2026-10-16 12:00:02 /c\n"}
+	for _, record := range records {
+		if _, err := writer.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Minute)
+	// Rolls the file, which is over the size limit with the header.
+	if _, err := writer.Write([]byte(records[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	data := strings.Join(records, "")
+	header := "#Version: 1.0\n#Date: 2026-10-16 12:00:00\n#Fields: date time cs-uri\n"
+	footer := fmt.Sprintf("#End-Date: 2026-10-16 12:01:00\n#Records: 3\n#Bytes: %d\n#CRC32: %08x\n", len(data), crc32.ChecksumIEEE([]byte(data)))
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog.1"), []byte(header+data+footer))
+
+	header = "#Version: 1.0\n#Date: 2026-10-16 12:01:00\n#Fields: date time cs-uri\n"
+	checkMemFileData(t, fs, filepath.Join("logs", "log.testlog"), []byte(header+records[0]))
+}
+
+func TestRollingFileWriterHeaderExistingFile(t *testing.T) {
+	fs := NewMemFileSystem()
+	path := filepath.Join("logs", "log.testlog")
+	if err := fs.WriteFile(path, bytesFileTest, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 0)
+	writer.Header = func(filePath string, start time.Time) []byte {
+		return []byte("header of " + filePath + "\n")
+	}
+	writer.Footer = func(summary FileSummary) []byte {
+		return []byte(fmt.Sprintf("%d writes\n", summary.Records))
+	}
+	defer writer.Close()
+
+	// The continued file gets no header, its footer counts the writes of the writer only.
+	writeMessages(t, writer, 2)
+	checkMemFileData(t, fs, path+".1", []byte(strings.Repeat("A", 2*messageLen)+"1 writes\n"))
+	checkMemFileData(t, fs, path, []byte("header of "+path+"\n"+strings.Repeat("A", messageLen)))
+}
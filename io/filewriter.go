@@ -26,59 +26,150 @@ package io
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // fileWriter is used to write to a file.
 type fileWriter struct {
-	innerWriter io.WriteCloser
+	innerWriter File
 	fileName    string
+	durability  SyncPolicy
+	permissions FilePermissions
+	fileSystem  FileSystem
+	syncer      fileSyncer
+	mutex       *sync.Mutex // Guards innerWriter, which is replaced by Reopen
 }
 
 // Creates a new file and a corresponding writer. Returns error, if the file couldn't be created.
 func NewFileWriter(fileName string) (writer *fileWriter, err error) {
 	newWriter := new(fileWriter)
 	newWriter.fileName = fileName
+	newWriter.mutex = new(sync.Mutex)
 
 	return newWriter, nil
 }
 
+// SetPermissions sets the modes and the ownership of the created file and directories.
+func (fw *fileWriter) SetPermissions(perms FilePermissions) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.permissions = perms
+}
+
+// SetDurability sets the policy of syncing written data to the disk. SyncNever by default.
+func (fw *fileWriter) SetDurability(policy SyncPolicy) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.durability = policy
+}
+
+// SetFileSystem sets the file system the file is created in. The file system of the OS by default.
+func (fw *fileWriter) SetFileSystem(fs FileSystem) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.fileSystem = fs
+}
+
+// Sync commits the written data to the disk regardless of the durability policy.
+func (fw *fileWriter) Sync() error {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	if fw.innerWriter == nil {
+		return nil
+	}
+	if err := fw.syncer.takeError(); err != nil {
+		return err
+	}
+	if err := fw.innerWriter.Sync(); err != nil {
+		return err
+	}
+	fw.syncer.unsynced = 0
+	return nil
+}
+
 func (fw *fileWriter) Close() error {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.closeFile()
+}
+
+// Reopen closes the file and opens it again by its name. It lets external tools
+// like logrotate move or truncate the file. Does nothing if the file is not open yet.
+func (fw *fileWriter) Reopen() error {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	if fw.innerWriter == nil {
+		return nil
+	}
+	if err := fw.closeFile(); err != nil {
+		return err
+	}
+	return fw.createFile()
+}
+
+func (fw *fileWriter) closeFile() error {
 	if fw.innerWriter != nil {
+		syncErr := fw.syncer.finish(fw.innerWriter, fw.durability)
 		err := fw.innerWriter.Close()
 		if err != nil {
 			return err
 		}
 		fw.innerWriter = nil
+		return syncErr
 	}
 	return nil
 }
 
 // Create folder and file on WriteLog/Write first call
 func (fw *fileWriter) Write(bytes []byte) (n int, err error) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
 	if fw.innerWriter == nil {
 		if err := fw.createFile(); err != nil {
 			return 0, err
 		}
 	}
-	return fw.innerWriter.Write(bytes)
+	n, err = fw.innerWriter.Write(bytes)
+	if err != nil {
+		return n, err
+	}
+	return n, fw.syncer.written(fw.innerWriter, n, fw.durability, fw.syncLater)
+}
+
+// syncLater is called by the timer of SyncInterval durability mode.
+func (fw *fileWriter) syncLater() {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.syncer.timerFired(fw.innerWriter)
 }
 
 func (fw *fileWriter) createFile() error {
+	fs := fw.fileSystem
+	if fs == nil {
+		fs = defaultFileSystem
+	}
 	folder, _ := filepath.Split(fw.fileName)
 	var err error
 
 	if 0 != len(folder) {
-		err = os.MkdirAll(folder, defaultDirectoryPermissions)
+		err = fw.permissions.mkdirAll(fs, folder)
 		if err != nil {
 			return err
 		}
 	}
 
 	// If exists
-	fw.innerWriter, err = os.OpenFile(fw.fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultFilePermissions)
+	fw.innerWriter, err = fw.permissions.openFile(fs, fw.fileName, os.O_WRONLY|os.O_APPEND)
 
 	if err != nil {
 		return err
@@ -93,13 +93,13 @@ func isWriterTestFile(fn string) bool {
 }
 
 func cleanupWriterTest(t *testing.T) {
-	toDel, err := getDirFilePaths(".", isWriterTestFile, true)
+	toDel, err := getDirFilePaths(defaultFileSystem, ".", isWriterTestFile, true)
 	if nil != err {
 		t.Fatal("Cannot list files in test directory!")
 	}
 
 	for _, p := range toDel {
-		if err = tryRemoveFile(p); nil != err {
+		if err = tryRemoveFile(defaultFileSystem, p); nil != err {
 			t.Errorf("cannot remove file %s in test directory: %s", p, err.Error())
 		}
 	}
@@ -251,3 +251,39 @@ func (tester *fileWriterTester) checkJustRequiredFilesExist(testCase *fileWriter
 		}
 	}
 }
+
+func TestFileWriterReopen(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewFileWriter("log.testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+
+	// logrotate in 'create' mode moves the file away.
+	if err := os.Rename("log.testlog", "log.testlog.old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"log.testlog", "log.testlog.old"} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Size() != messageLen {
+			t.Errorf("expected %d bytes in %s. Got: %d", messageLen, name, stat.Size())
+		}
+	}
+}
@@ -27,7 +27,9 @@ package io
 import (
 	"errors"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 // bytesVerifier is a byte receiver which is used for correct input testing.
@@ -37,6 +39,7 @@ type bytesVerifier struct {
 	waitingForInput bool   // true if verifier is waiting for a Write call
 	writtenData     []byte // real bytes that actually were received during the last Write call
 	testEnv         *testing.T
+	mutex           sync.Mutex // writes may come from other goroutines
 }
 
 func newBytesVerifier(t *testing.T) (*bytesVerifier, error) {
@@ -53,6 +56,9 @@ func newBytesVerifier(t *testing.T) (*bytesVerifier, error) {
 // Write is used to check whether verifier was waiting for input and whether bytes are the same as expectedBytes.
 // After Write call, waitingForInput is set to false.
 func (verifier *bytesVerifier) Write(bytes []byte) (n int, err error) {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
 	if !verifier.waitingForInput {
 		verifier.testEnv.Errorf("unexpected input: %v", string(bytes))
 		return
@@ -84,11 +90,35 @@ func (verifier *bytesVerifier) Write(bytes []byte) (n int, err error) {
 }
 
 func (verifier *bytesVerifier) ExpectBytes(bytes []byte) {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
 	verifier.waitingForInput = true
 	verifier.expectedBytes = bytes
 }
 
+// WaitForInput waits until the expected bytes are written, failing the test after the timeout.
+func (verifier *bytesVerifier) WaitForInput(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		verifier.mutex.Lock()
+		waiting := verifier.waitingForInput
+		verifier.mutex.Unlock()
+		if !waiting {
+			return
+		}
+		if time.Now().After(deadline) {
+			verifier.testEnv.Errorf("expected input was not written in %s", timeout)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func (verifier *bytesVerifier) MustNotExpect() {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
 	if verifier.waitingForInput {
 		errorText := "Unexpected input: "
 
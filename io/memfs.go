@@ -0,0 +1,584 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFileSystem is a FileSystem that keeps the files in memory. It makes the
+// tests of rolling, retention and archivation fast and deterministic.
+//
+// Paths are cleaned, relative ones are relative to the root. Symlinks are
+// followed only in the last path element. Modes are not checked.
+//
+// MemFileSystem is safe for concurrent use by multiple goroutines.
+type MemFileSystem struct {
+	// FailOn, if set, is called before every operation with the operation name
+	// and the path. A returned error, e.g. syscall.ENOSPC, fails the operation.
+	// The names are "open", "mkdir", "rename", "remove", "stat", "readdir",
+	// "symlink", "readlink", "read", "write", "sync", "chmod", "chown" and "statfs".
+	FailOn func(op, path string) error
+
+	// Clock the modification times are taken from. SystemClock if nil.
+	Clock Clock
+
+	// Capacity limits the total size of the files in bytes. Writes beyond it
+	// fail with ENOSPC. No limit if 0.
+	Capacity int64
+
+	mutex   sync.Mutex
+	nodes   map[string]*memNode // by cleaned path
+	tempSeq int
+}
+
+// memNode is a file, a directory or a symlink of a MemFileSystem.
+type memNode struct {
+	mode    os.FileMode
+	data    []byte
+	target  string // of a symlink
+	modTime time.Time
+	uid     int
+	gid     int
+}
+
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{nodes: make(map[string]*memNode)}
+}
+
+func (fs *MemFileSystem) now() time.Time {
+	if fs.Clock == nil {
+		return defaultClock.Now()
+	}
+	return fs.Clock.Now()
+}
+
+func memPathError(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+func isMemRoot(path string) bool {
+	return path == "." || filepath.Dir(path) == path
+}
+
+func (fs *MemFileSystem) fail(op, path string) error {
+	if fs.FailOn == nil {
+		return nil
+	}
+	if err := fs.FailOn(op, path); err != nil {
+		return memPathError(op, path, err)
+	}
+	return nil
+}
+
+// lookup returns the node of the cleaned path, following the symlinks if follow
+// is set. Roots are directories without nodes.
+func (fs *MemFileSystem) lookup(path string, follow bool) (string, *memNode, bool) {
+	for i := 0; ; i++ {
+		if isMemRoot(path) {
+			return path, &memNode{mode: os.ModeDir | 0777}, true
+		}
+		node, ok := fs.nodes[path]
+		if !ok || !follow || node.mode&os.ModeSymlink == 0 || i == 16 {
+			return path, node, ok
+		}
+		target := node.target
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = filepath.Clean(target)
+	}
+}
+
+// checkParent returns an error if the parent directory of the path does not exist.
+func (fs *MemFileSystem) checkParent(op, path string) error {
+	_, parent, ok := fs.lookup(filepath.Dir(path), true)
+	if !ok {
+		return memPathError(op, path, os.ErrNotExist)
+	}
+	if !parent.mode.IsDir() {
+		return memPathError(op, path, syscall.ENOTDIR)
+	}
+	return nil
+}
+
+func (fs *MemFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := fs.fail("open", name); err != nil {
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	path, node, ok := fs.lookup(filepath.Clean(name), true)
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if ok {
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, memPathError("open", name, os.ErrExist)
+		}
+		if node.mode.IsDir() && writable {
+			return nil, memPathError("open", name, syscall.EISDIR)
+		}
+		if flag&os.O_TRUNC != 0 && writable {
+			node.data = nil
+			node.modTime = fs.now()
+		}
+	} else {
+		if flag&os.O_CREATE == 0 {
+			return nil, memPathError("open", name, os.ErrNotExist)
+		}
+		if err := fs.checkParent("open", path); err != nil {
+			return nil, err
+		}
+		node = &memNode{mode: perm.Perm(), modTime: fs.now(), uid: -1, gid: -1}
+		fs.nodes[path] = node
+	}
+
+	return &memFile{fs: fs, node: node, name: name, flag: flag}, nil
+}
+
+func (fs *MemFileSystem) CreateTemp(dir, pattern string) (File, error) {
+	fs.mutex.Lock()
+	fs.tempSeq++
+	seq := strconv.Itoa(fs.tempSeq)
+	fs.mutex.Unlock()
+
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		pattern = pattern[:i] + seq + pattern[i+1:]
+	} else {
+		pattern += seq
+	}
+	return fs.OpenFile(filepath.Join(dir, pattern), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (fs *MemFileSystem) Mkdir(name string, perm os.FileMode) error {
+	if err := fs.fail("mkdir", name); err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	path := filepath.Clean(name)
+	if _, _, ok := fs.lookup(path, false); ok {
+		return memPathError("mkdir", name, os.ErrExist)
+	}
+	if err := fs.checkParent("mkdir", path); err != nil {
+		return err
+	}
+	fs.nodes[path] = &memNode{mode: os.ModeDir | perm.Perm(), modTime: fs.now(), uid: -1, gid: -1}
+	return nil
+}
+
+func (fs *MemFileSystem) Rename(oldpath, newpath string) error {
+	if err := fs.fail("rename", oldpath); err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	oldClean, newClean := filepath.Clean(oldpath), filepath.Clean(newpath)
+	node, ok := fs.nodes[oldClean]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if oldClean == newClean {
+		return nil
+	}
+	if err := fs.checkParent("rename", newClean); err != nil {
+		return err
+	}
+	if existing, ok := fs.nodes[newClean]; ok && existing.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+
+	delete(fs.nodes, oldClean)
+	fs.nodes[newClean] = node
+	if node.mode.IsDir() {
+		prefix := oldClean + string(filepath.Separator)
+		for path, child := range fs.nodes {
+			if strings.HasPrefix(path, prefix) {
+				delete(fs.nodes, path)
+				fs.nodes[filepath.Join(newClean, path[len(prefix):])] = child
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *MemFileSystem) Remove(name string) error {
+	if err := fs.fail("remove", name); err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	path := filepath.Clean(name)
+	node, ok := fs.nodes[path]
+	if !ok {
+		return memPathError("remove", name, os.ErrNotExist)
+	}
+	if node.mode.IsDir() && len(fs.children(path)) != 0 {
+		return memPathError("remove", name, errMemNotEmpty)
+	}
+	delete(fs.nodes, path)
+	return nil
+}
+
+func (fs *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	return fs.stat("stat", name, true)
+}
+
+func (fs *MemFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return fs.stat("stat", name, false)
+}
+
+func (fs *MemFileSystem) stat(op, name string, follow bool) (os.FileInfo, error) {
+	if err := fs.fail(op, name); err != nil {
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	path, node, ok := fs.lookup(filepath.Clean(name), follow)
+	if !ok {
+		return nil, memPathError(op, name, os.ErrNotExist)
+	}
+	return newMemFileInfo(filepath.Base(path), node), nil
+}
+
+// children returns the paths of the direct children of the directory.
+func (fs *MemFileSystem) children(dirPath string) []string {
+	var paths []string
+	for path := range fs.nodes {
+		if filepath.Dir(path) == dirPath && path != dirPath {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (fs *MemFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	if err := fs.fail("readdir", name); err != nil {
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	path, node, ok := fs.lookup(filepath.Clean(name), true)
+	if !ok {
+		return nil, memPathError("readdir", name, os.ErrNotExist)
+	}
+	if !node.mode.IsDir() {
+		return nil, memPathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	var infos []os.FileInfo
+	for _, child := range fs.children(path) {
+		infos = append(infos, newMemFileInfo(filepath.Base(child), fs.nodes[child]))
+	}
+	return infos, nil
+}
+
+func (fs *MemFileSystem) Symlink(oldname, newname string) error {
+	if err := fs.fail("symlink", newname); err != nil {
+		return err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	path := filepath.Clean(newname)
+	if _, _, ok := fs.lookup(path, false); ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if err := fs.checkParent("symlink", path); err != nil {
+		return err
+	}
+	fs.nodes[path] = &memNode{mode: os.ModeSymlink | 0777, target: oldname, modTime: fs.now(), uid: -1, gid: -1}
+	return nil
+}
+
+func (fs *MemFileSystem) Readlink(name string) (string, error) {
+	if err := fs.fail("readlink", name); err != nil {
+		return "", err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	node, ok := fs.nodes[filepath.Clean(name)]
+	if !ok {
+		return "", memPathError("readlink", name, os.ErrNotExist)
+	}
+	if node.mode&os.ModeSymlink == 0 {
+		return "", memPathError("readlink", name, syscall.EINVAL)
+	}
+	return node.target, nil
+}
+
+func (fs *MemFileSystem) SameFile(fi1, fi2 os.FileInfo) bool {
+	node1, ok1 := fi1.Sys().(*memNode)
+	node2, ok2 := fi2.Sys().(*memNode)
+	return ok1 && ok2 && node1 == node2
+}
+
+// FreeSpace returns the capacity left, or math.MaxInt64 if there is no limit.
+func (fs *MemFileSystem) FreeSpace(path string) (int64, error) {
+	if err := fs.fail("statfs", path); err != nil {
+		return 0, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return fs.freeSpace(), nil
+}
+
+func (fs *MemFileSystem) freeSpace() int64 {
+	if fs.Capacity <= 0 {
+		return math.MaxInt64
+	}
+	free := fs.Capacity
+	for _, node := range fs.nodes {
+		free -= int64(len(node.data))
+	}
+	return free
+}
+
+// ReadFile returns the contents of the file.
+func (fs *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// WriteFile creates or truncates the file and writes the data to it, creating
+// the missing parent directories.
+func (fs *MemFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	if dir := filepath.Dir(filepath.Clean(name)); !isMemRoot(dir) {
+		if err := (FilePermissions{}).mkdirAll(fs, dir); err != nil {
+			return err
+		}
+	}
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Chtimes changes the modification time of the file.
+func (fs *MemFileSystem) Chtimes(name string, mtime time.Time) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	_, node, ok := fs.lookup(filepath.Clean(name), true)
+	if !ok {
+		return memPathError("chtimes", name, os.ErrNotExist)
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// memFile is an open file of a MemFileSystem. The data of the node is shared by
+// all the open files, which keep working after a rename or a removal.
+type memFile struct {
+	fs     *MemFileSystem
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) check(op string) error {
+	if f.closed {
+		return memPathError(op, f.name, os.ErrClosed)
+	}
+	return f.fs.fail(op, f.name)
+}
+
+func (f *memFile) Read(p []byte) (n int, err error) {
+	if err = f.check("read"); err != nil {
+		return 0, err
+	}
+
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if err = f.check("read"); err != nil {
+		return 0, err
+	}
+
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (n int, err error) {
+	if err = f.check("write"); err != nil {
+		return 0, err
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, memPathError("write", f.name, os.ErrPermission)
+	}
+
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	// Like on a full disk, the data that fits is written.
+	if grow := f.offset + int64(len(p)) - int64(len(f.node.data)); grow > 0 {
+		if free := f.fs.freeSpace(); grow > free {
+			if free < 0 {
+				free = 0
+			}
+			p = p[:int64(len(p))-grow+free]
+			err = memPathError("write", f.name, errMemNoSpace)
+		}
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset += int64(len(p))
+	f.node.modTime = f.fs.now()
+	return len(p), err
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return memPathError("close", f.name, os.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if err := f.check("stat"); err != nil {
+		return nil, err
+	}
+
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	return newMemFileInfo(filepath.Base(f.name), f.node), nil
+}
+
+func (f *memFile) Sync() error {
+	return f.check("sync")
+}
+
+func (f *memFile) Chmod(mode os.FileMode) error {
+	if err := f.check("chmod"); err != nil {
+		return err
+	}
+
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	f.node.mode = f.node.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+func (f *memFile) Chown(uid, gid int) error {
+	if err := f.check("chown"); err != nil {
+		return err
+	}
+
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if uid != -1 {
+		f.node.uid = uid
+	}
+	if gid != -1 {
+		f.node.gid = gid
+	}
+	return nil
+}
+
+// memFileInfo is a snapshot of a memNode.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	node    *memNode
+}
+
+func newMemFileInfo(name string, node *memNode) *memFileInfo {
+	return &memFileInfo{name, int64(len(node.data)), node.mode, node.modTime, node}
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return fi.node }
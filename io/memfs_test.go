@@ -0,0 +1,323 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func memDirFiles(t *testing.T, fs FileSystem, dirPath string) []string {
+	files, err := getDirFilePaths(fs, dirPath, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func checkMemDirFiles(t *testing.T, fs FileSystem, dirPath string, expected ...string) {
+	files := memDirFiles(t, fs, dirPath)
+	sort.Strings(expected)
+	if len(files) != len(expected) {
+		t.Fatalf("%s: expected files %v. Got: %v", dirPath, expected, files)
+	}
+	for i := range files {
+		if files[i] != expected[i] {
+			t.Fatalf("%s: expected files %v. Got: %v", dirPath, expected, files)
+		}
+	}
+}
+
+func newMemSizeWriter(t *testing.T, fs FileSystem, atype RollingArchiveType, maxRolls int) *RollingFileWriterSize {
+	writer, err := NewRollingFileWriterSize(filepath.Join("logs", "log.testlog"), atype, "", 2*messageLen, maxRolls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.FileSystem = fs
+	return writer
+}
+
+func writeMessages(t *testing.T, w io.Writer, count int) {
+	for i := 0; i < count; i++ {
+		if _, err := w.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemFileSystemRolling(t *testing.T) {
+	fs := NewMemFileSystem()
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 2)
+	writeMessages(t, writer, 7)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.2", "log.testlog.3")
+	data, err := fs.ReadFile(filepath.Join("logs", "log.testlog.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Repeat(bytesFileTest, 2)) {
+		t.Errorf("unexpected roll contents: %q", data)
+	}
+	if _, err := os.Stat("logs"); !os.IsNotExist(err) {
+		t.Errorf("expected nothing on the disk. Got: %v", err)
+	}
+}
+
+func TestMemFileSystemRetentionByAge(t *testing.T) {
+	fs := NewMemFileSystem()
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 0)
+	writer.MaxAge = time.Hour
+	defer writer.Close()
+
+	writeMessages(t, writer, 5)
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.1", "log.testlog.2")
+
+	if err := fs.Chtimes(filepath.Join("logs", "log.testlog.1"), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	writeMessages(t, writer, 2)
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.2", "log.testlog.3")
+}
+
+func TestMemFileSystemGzip(t *testing.T) {
+	fs := NewMemFileSystem()
+	writer := newMemSizeWriter(t, fs, RollingArchiveGzip, 0)
+	writeMessages(t, writer, 3)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.1.gz")
+	data, err := fs.ReadFile(filepath.Join("logs", "log.testlog.1.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Repeat(bytesFileTest, 2)) {
+		t.Errorf("unexpected roll contents: %q", data)
+	}
+}
+
+func TestMemFileSystemZip(t *testing.T) {
+	fs := NewMemFileSystem()
+	writer := newMemSizeWriter(t, fs, RollingArchiveZip, 1)
+	writeMessages(t, writer, 7)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.3", "log.zip")
+	data, err := fs.ReadFile(filepath.Join("logs", "log.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "log.testlog.1" || names[1] != "log.testlog.2" {
+		t.Errorf("unexpected archive entries: %v", names)
+	}
+}
+
+func TestMemFileSystemZipUniqueNames(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	fs := NewMemFileSystem()
+	fs.Clock = clock
+
+	// Every writer archives the expired history on start, so roll numbers start over.
+	for i := 0; i < 3; i++ {
+		writer := newMemSizeWriter(t, fs, RollingArchiveZip, 0)
+		writer.Clock = clock
+		writer.MaxAge = time.Hour
+		writeMessages(t, writer, 2)
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(2 * time.Hour)
+	}
+	writer := newMemSizeWriter(t, fs, RollingArchiveZip, 0)
+	writer.Clock = clock
+	writer.MaxAge = time.Hour
+	writeMessages(t, writer, 1)
+	writer.Close()
+
+	data, err := fs.ReadFile(filepath.Join("logs", "log.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	expected := []string{"log.testlog.1", "log.testlog.1~2", "log.testlog.1~3"}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected archive entries %v. Got: %v", expected, names)
+	}
+}
+
+func TestMemFileSystemZipSplit(t *testing.T) {
+	fs := NewMemFileSystem()
+	writer := newMemSizeWriter(t, fs, RollingArchiveZip, 1)
+	// Every archive gets a single roll.
+	writer.MaxArchiveSize = 1
+	writeMessages(t, writer, 9)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.4", "log.1.zip", "log.2.zip", "log.3.zip")
+	for i := 1; i <= 3; i++ {
+		data, err := fs.ReadFile(filepath.Join("logs", fmt.Sprintf("log.%d.zip", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != 1 || zr.File[0].Name != fmt.Sprintf("log.testlog.%d", i) {
+			t.Errorf("log.%d.zip: unexpected entries %v", i, zr.File)
+		}
+	}
+}
+
+func TestMemFileSystemRenameFailure(t *testing.T) {
+	fs := NewMemFileSystem()
+	noSpace := true
+	fs.FailOn = func(op, path string) error {
+		if op == "rename" && noSpace {
+			return errMemNoSpace
+		}
+		return nil
+	}
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 0)
+	defer writer.Close()
+
+	writeMessages(t, writer, 2)
+	if _, err := writer.Write(bytesFileTest); !errors.Is(err, errMemNoSpace) {
+		t.Fatalf("expected ENOSPC. Got: %v", err)
+	}
+	checkMemDirFiles(t, fs, "logs", "log.testlog")
+
+	// The writer recovers as soon as the roll succeeds.
+	noSpace = false
+	writeMessages(t, writer, 1)
+	checkMemDirFiles(t, fs, "logs", "log.testlog", "log.testlog.1")
+	data, err := fs.ReadFile(filepath.Join("logs", "log.testlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytesFileTest) {
+		t.Errorf("unexpected current file contents: %q", data)
+	}
+}
+
+func TestMemFileSystemWriteFailure(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.FailOn = func(op, path string) error {
+		if op == "write" {
+			return errMemNoSpace
+		}
+		return nil
+	}
+	writer, err := NewFileWriter(filepath.Join("logs", "log.testlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.SetFileSystem(fs)
+	defer writer.Close()
+
+	if _, err := writer.Write(bytesFileTest); !errors.Is(err, errMemNoSpace) {
+		t.Fatalf("expected ENOSPC. Got: %v", err)
+	}
+	checkMemDirFiles(t, fs, "logs", "log.testlog")
+}
+
+func TestMemFileSystemProcessLocking(t *testing.T) {
+	writer := newMemSizeWriter(t, NewMemFileSystem(), RollingArchiveNone, 0)
+	writer.ProcessLocking = true
+	defer writer.Close()
+
+	if _, err := writer.Write(bytesFileTest); err != errProcessLockingFileSystem {
+		t.Errorf("expected %v. Got: %v", errProcessLockingFileSystem, err)
+	}
+}
+
+func TestMemFileSystemRenameOpenFile(t *testing.T) {
+	fs := NewMemFileSystem()
+	f, err := fs.OpenFile("a", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Rename("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if _, err = fs.Stat("a"); !os.IsNotExist(err) {
+		t.Errorf("expected a not to exist. Got: %v", err)
+	}
+	data, err := fs.ReadFile("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected data. Got: %q", data)
+	}
+}
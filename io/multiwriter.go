@@ -0,0 +1,301 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SinkOptions are the settings of a MultiWriter sink.
+type SinkOptions struct {
+	BufferSize  int           // Data is buffered by a BufferedWriter if greater than 0
+	FlushPeriod time.Duration // Flush period of the BufferedWriter. Failures of periodic flushes are tracked too
+	KeepOpen    bool          // The writer is not closed on removal, e.g. for os.Stdout
+}
+
+// SinkStatus is the health of a MultiWriter sink.
+type SinkStatus struct {
+	Name                string
+	Healthy             bool  // The last write to the sink succeeded. For buffered sinks, the last flush too
+	LastError           error // Error of the last failed write
+	LastFailure         time.Time
+	Writes              uint64 // Successful writes
+	Failures            uint64 // Failed writes
+	ConsecutiveFailures int
+}
+
+// sink is a writer of a MultiWriter along with its health.
+type sink struct {
+	writer  io.Writer  // BufferedWriter if the sink is buffered
+	status  SinkStatus // Guarded by MultiWriter.statusMutex
+	flushes uint64     // Flushes of the buffered sink done before the last failure
+}
+
+// writerOnly hides the Close method of a writer that must be kept open.
+type writerOnly struct {
+	io.Writer
+}
+
+// MultiWriter duplicates the writes to all of its sinks. Unlike io.MultiWriter,
+// a failed sink does not stop the writes to the other ones: failures are only
+// recorded in the sink status and passed to ErrorHandler, if it is set. A write
+// fails only if all the sinks fail.
+//
+// Sinks are written to one after another, so slow sinks should be buffered.
+// Sinks can be added and removed while the writer is in use.
+type MultiWriter struct {
+	// Failed sinks are skipped until RetryInterval passes since the last failure.
+	// 0 - failed sinks are retried by every write.
+	RetryInterval time.Duration
+
+	// ErrorHandler is called with the writer or, for periodic flushes of buffered
+	// sinks, the sink buffer locked, so it must not use the writer.
+	ErrorHandler func(sinkName string, err error)
+
+	mutex       *sync.Mutex
+	statusMutex *sync.Mutex // Guards the sink statuses, which periodic flushes update as well
+	sinks       []*sink
+}
+
+func NewMultiWriter() *MultiWriter {
+	multiWriter := new(MultiWriter)
+	multiWriter.mutex = new(sync.Mutex)
+	multiWriter.statusMutex = new(sync.Mutex)
+	return multiWriter
+}
+
+// AddSink adds a writer with a unique name to the sinks.
+func (multiWriter *MultiWriter) AddSink(name string, writer io.Writer, options SinkOptions) error {
+	if writer == nil {
+		return errors.New("argument is nil: writer")
+	}
+
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	if multiWriter.findSink(name) >= 0 {
+		return fmt.Errorf("sink already exists: %s", name)
+	}
+
+	s := &sink{writer: writer, status: SinkStatus{Name: name, Healthy: true}}
+	if options.KeepOpen {
+		s.writer = writerOnly{writer}
+	}
+	if options.BufferSize > 0 {
+		if options.FlushPeriod < 0 {
+			return fmt.Errorf("flushPeriod can not be less than 0. Got: %s", options.FlushPeriod)
+		}
+		// BufferedWriter takes the period in milliseconds.
+		bufWriter, err := NewBufferedWriter(s.writer, options.BufferSize, options.FlushPeriod/time.Millisecond)
+		if err != nil {
+			return err
+		}
+		// All the failures of the inner writer, including the ones of periodic
+		// flushes, are recorded here. The buffer is locked, so its stats can be read.
+		bufWriter.ErrorHandler = func(err error) {
+			multiWriter.sinkFailed(s, err, time.Now(), bufWriter.stats.Flushes)
+		}
+		s.writer = bufWriter
+	}
+
+	multiWriter.sinks = append(multiWriter.sinks, s)
+	return nil
+}
+
+// RemoveSink removes the sink, flushing and closing it unless it was added with KeepOpen.
+func (multiWriter *MultiWriter) RemoveSink(name string) error {
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	i := multiWriter.findSink(name)
+	if i < 0 {
+		return fmt.Errorf("unknown sink: %s", name)
+	}
+	s := multiWriter.sinks[i]
+	multiWriter.sinks = append(multiWriter.sinks[:i:i], multiWriter.sinks[i+1:]...)
+	return closeSink(s)
+}
+
+func (multiWriter *MultiWriter) findSink(name string) int {
+	for i, s := range multiWriter.sinks {
+		if s.status.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func closeSink(s *sink) error {
+	if closer, ok := s.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Write writes the data to all the healthy sinks and the failed ones that are
+// due to a retry. It returns an error only if no sink accepted the data.
+func (multiWriter *MultiWriter) Write(bytes []byte) (n int, err error) {
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	now := time.Now()
+	written := false
+	var errs []error
+	for _, s := range multiWriter.sinks {
+		status := multiWriter.sinkStatus(s)
+		if !status.Healthy && now.Sub(status.LastFailure) < multiWriter.RetryInterval {
+			errs = append(errs, fmt.Errorf("sink %s: %w", status.Name, status.LastError))
+			continue
+		}
+
+		sinkN, sinkErr := s.writer.Write(bytes)
+		if sinkErr == nil && sinkN < len(bytes) {
+			sinkErr = io.ErrShortWrite
+		}
+		if sinkErr != nil {
+			multiWriter.sinkWriteFailed(s, sinkErr, now, status.Failures)
+			errs = append(errs, fmt.Errorf("sink %s: %w", status.Name, sinkErr))
+			continue
+		}
+
+		multiWriter.sinkWritten(s)
+		written = true
+	}
+
+	if !written && len(errs) != 0 {
+		return 0, errors.Join(errs...)
+	}
+	return len(bytes), nil
+}
+
+func (multiWriter *MultiWriter) sinkStatus(s *sink) SinkStatus {
+	multiWriter.statusMutex.Lock()
+	defer multiWriter.statusMutex.Unlock()
+
+	return s.status
+}
+
+// sinkWritten records a successful write. A buffered sink that failed stays
+// unhealthy until the buffer is flushed.
+func (multiWriter *MultiWriter) sinkWritten(s *sink) {
+	var flushes uint64
+	bufWriter, buffered := s.writer.(*BufferedWriter)
+	if buffered {
+		flushes = bufWriter.Stats().Flushes
+	}
+
+	multiWriter.statusMutex.Lock()
+	defer multiWriter.statusMutex.Unlock()
+
+	s.status.Writes++
+	if !buffered || s.status.Healthy || flushes > s.flushes {
+		s.status.Healthy = true
+		s.status.ConsecutiveFailures = 0
+	}
+}
+
+// sinkWriteFailed records a failure of a write or a flush, unless the sink
+// buffer already did, i.e. the failures changed since the status was taken.
+func (multiWriter *MultiWriter) sinkWriteFailed(s *sink, err error, now time.Time, failures uint64) {
+	multiWriter.statusMutex.Lock()
+	recorded := s.status.Failures != failures
+	flushes := s.flushes
+	multiWriter.statusMutex.Unlock()
+
+	if !recorded {
+		multiWriter.sinkFailed(s, err, now, flushes)
+	}
+}
+
+func (multiWriter *MultiWriter) sinkFailed(s *sink, err error, now time.Time, flushes uint64) {
+	multiWriter.statusMutex.Lock()
+	s.status.Healthy = false
+	s.status.LastError = err
+	s.status.LastFailure = now
+	s.status.Failures++
+	s.status.ConsecutiveFailures++
+	s.flushes = flushes
+	multiWriter.statusMutex.Unlock()
+
+	if multiWriter.ErrorHandler != nil {
+		multiWriter.ErrorHandler(s.status.Name, err)
+	}
+}
+
+// Flush flushes the buffered sinks. Failures are recorded like the ones of writes.
+func (multiWriter *MultiWriter) Flush() error {
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	var errs []error
+	for _, s := range multiWriter.sinks {
+		if bufWriter, ok := s.writer.(*BufferedWriter); ok {
+			status := multiWriter.sinkStatus(s)
+			if err := bufWriter.Flush(); err != nil {
+				multiWriter.sinkWriteFailed(s, err, time.Now(), status.Failures)
+				errs = append(errs, fmt.Errorf("sink %s: %w", status.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sinks returns the statuses of all the sinks in the order they were added.
+func (multiWriter *MultiWriter) Sinks() []SinkStatus {
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	statuses := make([]SinkStatus, len(multiWriter.sinks))
+	for i, s := range multiWriter.sinks {
+		statuses[i] = multiWriter.sinkStatus(s)
+	}
+	return statuses
+}
+
+// Close removes all the sinks, flushing and closing them unless they were added with KeepOpen.
+func (multiWriter *MultiWriter) Close() error {
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	var errs []error
+	for _, s := range multiWriter.sinks {
+		if err := closeSink(s); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", s.status.Name, err))
+		}
+	}
+	multiWriter.sinks = nil
+	return errors.Join(errs...)
+}
+
+func (multiWriter *MultiWriter) String() string {
+	multiWriter.mutex.Lock()
+	defer multiWriter.mutex.Unlock()
+
+	return fmt.Sprintf("MultiWriter sinks: %d", len(multiWriter.sinks))
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiWriterFailureIsolation(t *testing.T) {
+	healthy := new(bytes.Buffer)
+	failing := &flakyWriter{fail: true}
+
+	writer := NewMultiWriter()
+	defer writer.Close()
+	var handled []string
+	writer.ErrorHandler = func(sinkName string, err error) { handled = append(handled, sinkName) }
+
+	writer.AddSink("healthy", healthy, SinkOptions{})
+	writer.AddSink("failing", failing, SinkOptions{})
+	if err := writer.AddSink("healthy", healthy, SinkOptions{}); err == nil {
+		t.Errorf("expected an error for a duplicate sink")
+	}
+
+	for i := 0; i < 2; i++ {
+		if n, err := writer.Write(bytesFileTest); n != messageLen || err != nil {
+			t.Errorf("expected write to succeed. Got: %d, %v", n, err)
+		}
+	}
+	if healthy.Len() != 2*messageLen {
+		t.Errorf("expected healthy sink to get all the data. Got: %q", healthy.String())
+	}
+
+	sinks := writer.Sinks()
+	if !sinks[0].Healthy || sinks[0].Writes != 2 {
+		t.Errorf("unexpected status of the healthy sink: %+v", sinks[0])
+	}
+	if sinks[1].Healthy || sinks[1].Failures != 2 || sinks[1].ConsecutiveFailures != 2 || !errors.Is(sinks[1].LastError, errTestWrite) {
+		t.Errorf("unexpected status of the failing sink: %+v", sinks[1])
+	}
+	if len(handled) != 2 || handled[0] != "failing" {
+		t.Errorf("unexpected handled errors: %v", handled)
+	}
+
+	// The sink recovers.
+	failing.fail = false
+	writer.Write(bytesFileTest)
+	if sinks = writer.Sinks(); !sinks[1].Healthy || sinks[1].ConsecutiveFailures != 0 || sinks[1].Writes != 1 {
+		t.Errorf("unexpected status of the recovered sink: %+v", sinks[1])
+	}
+}
+
+func TestMultiWriterAllSinksFail(t *testing.T) {
+	writer := NewMultiWriter()
+	defer writer.Close()
+	writer.AddSink("failing", &flakyWriter{fail: true}, SinkOptions{})
+
+	if n, err := writer.Write(bytesFileTest); n != 0 || !errors.Is(err, errTestWrite) {
+		t.Errorf("expected write to fail. Got: %d, %v", n, err)
+	}
+}
+
+func TestMultiWriterRetryInterval(t *testing.T) {
+	failing := &flakyWriter{fail: true, partial: 1}
+
+	writer := NewMultiWriter()
+	defer writer.Close()
+	writer.RetryInterval = time.Hour
+	writer.AddSink("failing", failing, SinkOptions{})
+
+	writer.Write(bytesFileTest)
+	writer.Write(bytesFileTest)
+	if len(failing.data) != 1 {
+		t.Errorf("expected the failed sink to be skipped. Got: %q", failing.data)
+	}
+}
+
+func TestMultiWriterSinks(t *testing.T) {
+	kept, buffered, removed := new(closeCounter), new(closeCounter), new(closeCounter)
+
+	writer := NewMultiWriter()
+	writer.AddSink("kept", kept, SinkOptions{KeepOpen: true})
+	writer.AddSink("buffered", buffered, SinkOptions{BufferSize: 1024, FlushPeriod: time.Second})
+	writer.AddSink("removed", removed, SinkOptions{})
+
+	if err := writer.RemoveSink("removed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.RemoveSink("removed"); err == nil {
+		t.Errorf("expected an error for an unknown sink")
+	}
+	if removed.closed != 1 {
+		t.Errorf("expected the removed sink to be closed")
+	}
+
+	sinks := writer.Sinks()
+	if len(sinks) != 2 || sinks[0].Name != "kept" || sinks[1].Name != "buffered" {
+		t.Errorf("unexpected sinks: %+v", sinks)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if kept.closed != 0 || buffered.closed != 1 {
+		t.Errorf("unexpected closes: kept %d, buffered %d", kept.closed, buffered.closed)
+	}
+}
+
+func TestMultiWriterBufferedSink(t *testing.T) {
+	sink := new(bytes.Buffer)
+
+	writer := NewMultiWriter()
+	defer writer.Close()
+	writer.AddSink("buffered", sink, SinkOptions{BufferSize: 1024})
+
+	writer.Write(bytesFileTest)
+	if sink.Len() != 0 {
+		t.Errorf("expected the data to be buffered")
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.Len() != messageLen {
+		t.Errorf("expected the data to be flushed. Got: %q", sink.String())
+	}
+}
+
+func TestMultiWriterBufferedSinkPeriodicFlush(t *testing.T) {
+	writer := NewMultiWriter()
+	defer writer.Close()
+	handled := make(chan string, 10)
+	writer.ErrorHandler = func(sinkName string, err error) {
+		select {
+		case handled <- sinkName:
+		default:
+		}
+	}
+	writer.AddSink("buffered", &flakyWriter{fail: true}, SinkOptions{BufferSize: 1024, FlushPeriod: 10 * time.Millisecond})
+
+	// The failure of a periodic flush makes the sink unhealthy.
+	writer.Write(bytesFileTest)
+	select {
+	case name := <-handled:
+		if name != "buffered" {
+			t.Errorf("unexpected handled sink: %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure of the periodic flush is not reported")
+	}
+	sinks := writer.Sinks()
+	if sinks[0].Healthy || sinks[0].Failures == 0 || !errors.Is(sinks[0].LastError, errTestWrite) {
+		t.Errorf("unexpected status after the failed flush: %+v", sinks[0])
+	}
+
+	// Writes to the buffer do not make it healthy again while the flushes fail.
+	writer.Write(bytesFileTest)
+	if sinks = writer.Sinks(); sinks[0].Healthy || sinks[0].Writes != 2 {
+		t.Errorf("unexpected status after the buffered write: %+v", sinks[0])
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// RollingNamer is a file naming strategy of the rolling writers. Rolling writers
+// identify files by their tails (roll number, time part, etc.), and the namer
+// decides where the tail is put in the file name.
+type RollingNamer interface {
+	// FileName returns the name of the file with the given tail. An empty tail
+	// stands for the original file name.
+	FileName(originalName, tail string) string
+
+	// FileTail extracts the tail from the file name. Returns false if the file
+	// does not belong to originalName. The original file name has an empty tail.
+	FileTail(originalName, fileName string) (string, bool)
+}
+
+// SuffixRollingNamer appends tails to the original file name: app.log.1,
+// app.log.2026-10-16. This is the default naming of the rolling writers.
+type SuffixRollingNamer struct {
+	Delimiter string
+}
+
+func (namer SuffixRollingNamer) FileName(originalName, tail string) string {
+	if len(tail) == 0 {
+		return originalName
+	}
+	return originalName + namer.Delimiter + tail
+}
+
+func (namer SuffixRollingNamer) FileTail(originalName, fileName string) (string, bool) {
+	if fileName == originalName {
+		return "", true
+	}
+	pref := originalName + namer.Delimiter
+	if len(fileName) <= len(pref) || !strings.HasPrefix(fileName, pref) {
+		return "", false
+	}
+	return fileName[len(pref):], true
+}
+
+// ExtensionRollingNamer puts tails before the extension of the original file
+// name, so that it stays the last: app-1.log, app-2026-10-16.1.log.
+type ExtensionRollingNamer struct {
+	Delimiter string
+}
+
+func (namer ExtensionRollingNamer) FileName(originalName, tail string) string {
+	if len(tail) == 0 {
+		return originalName
+	}
+	ext := filepath.Ext(originalName)
+	return originalName[:len(originalName)-len(ext)] + namer.Delimiter + tail + ext
+}
+
+func (namer ExtensionRollingNamer) FileTail(originalName, fileName string) (string, bool) {
+	if fileName == originalName {
+		return "", true
+	}
+	ext := filepath.Ext(originalName)
+	pref := originalName[:len(originalName)-len(ext)] + namer.Delimiter
+	if len(fileName) <= len(pref)+len(ext) || !strings.HasPrefix(fileName, pref) || !strings.HasSuffix(fileName, ext) {
+		return "", false
+	}
+	return fileName[len(pref) : len(fileName)-len(ext)], true
+}
+
+// Default naming of the rolling writers.
+var defaultRollingNamer RollingNamer = SuffixRollingNamer{rollingLogHistoryDelimiter}
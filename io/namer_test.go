@@ -0,0 +1,116 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRollingNamers(t *testing.T) {
+	tests := []struct {
+		namer    RollingNamer
+		original string
+		tail     string
+		fileName string
+	}{
+		{SuffixRollingNamer{"."}, "app.log", "", "app.log"},
+		{SuffixRollingNamer{"."}, "app.log", "1", "app.log.1"},
+		{SuffixRollingNamer{"."}, "app.log", "2026-10-16.1", "app.log.2026-10-16.1"},
+		{ExtensionRollingNamer{"-"}, "app.log", "", "app.log"},
+		{ExtensionRollingNamer{"-"}, "app.log", "1", "app-1.log"},
+		{ExtensionRollingNamer{"-"}, "app.log", "2026-10-16.1", "app-2026-10-16.1.log"},
+		{ExtensionRollingNamer{"-"}, "app", "2026-10-16", "app-2026-10-16"},
+	}
+
+	for _, test := range tests {
+		fileName := test.namer.FileName(test.original, test.tail)
+		if fileName != test.fileName {
+			t.Errorf("%T: expected file name %s. Got: %s", test.namer, test.fileName, fileName)
+		}
+		tail, ok := test.namer.FileTail(test.original, test.fileName)
+		if !ok || tail != test.tail {
+			t.Errorf("%T: expected tail %q of %s. Got: %q, %v", test.namer, test.tail, test.fileName, tail, ok)
+		}
+	}
+
+	foreign := []struct {
+		namer    RollingNamer
+		fileName string
+	}{
+		{SuffixRollingNamer{"."}, "app.log."},
+		{SuffixRollingNamer{"."}, "other.log.1"},
+		{ExtensionRollingNamer{"-"}, "app-.log"},
+		{ExtensionRollingNamer{"-"}, "app-1.txt"},
+		{ExtensionRollingNamer{"-"}, "app.log.1"},
+	}
+	for _, test := range foreign {
+		if tail, ok := test.namer.FileTail("app.log", test.fileName); ok {
+			t.Errorf("%T: file %s must not belong to app.log. Got tail: %q", test.namer, test.fileName, tail)
+		}
+	}
+}
+
+func TestRollingFileWriterNamerLayout(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSizeTime(filepath.Join("dir", "app.testlog"), RollingArchiveNone, "", 10, 0, testDatePattern, RollingIntervalDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Namer = ExtensionRollingNamer{"-"}
+	writer.HistoryDirPath = filepath.Join("dir", "history")
+	writer.CurrentLinkName = "current.testlog"
+
+	for i := 0; i < 3; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join("dir", "app-"+testDate+".testlog"),
+		filepath.Join("dir", "history", "app-"+testDate+".1.testlog"),
+		filepath.Join("dir", "history", "app-"+testDate+".2.testlog"),
+	}
+	for _, f := range expected {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("expected file %s: %s", f, err)
+		}
+	}
+
+	target, err := os.Readlink(filepath.Join("dir", "current.testlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "app-"+testDate+".testlog" {
+		t.Errorf("expected link to the active file. Got: %s", target)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// FilePermissions sets the modes and the ownership of the files and directories
+// created by a writer: the active files, compressed rolls and archives. Rolls
+// keep the permissions of the active file they are renamed from. Existing files
+// and directories are left as they are.
+//
+// Modes that are set are applied as is, regardless of the umask. The zero value
+// keeps the default modes restricted by the umask and the owner of the process.
+type FilePermissions struct {
+	FileMode os.FileMode // 0666 restricted by the umask if 0
+	DirMode  os.FileMode // 0755 restricted by the umask if 0
+
+	// If Chown is set, created files and directories are given to Uid and Gid.
+	// -1 leaves the corresponding id unchanged.
+	Chown bool
+	Uid   int
+	Gid   int
+}
+
+// apply sets the modes and the owner of the just created file or directory.
+func (perms FilePermissions) apply(f File, mode os.FileMode) error {
+	if mode != 0 {
+		if err := f.Chmod(mode); err != nil {
+			return err
+		}
+	}
+	if perms.Chown {
+		return f.Chown(perms.Uid, perms.Gid)
+	}
+	return nil
+}
+
+// openFile opens the file with the given flags, creating it with the permissions
+// if it does not exist.
+func (perms FilePermissions) openFile(fs FileSystem, filePath string, flag int) (File, error) {
+	for {
+		f, err := fs.OpenFile(filePath, flag|os.O_CREATE|os.O_EXCL, perms.fileMode())
+		if err == nil {
+			if err = perms.apply(f, perms.FileMode); err != nil {
+				f.Close()
+				return nil, err
+			}
+			return f, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		f, err = fs.OpenFile(filePath, flag, 0)
+		// The file may be removed in between, then it is created again.
+		if !os.IsNotExist(err) {
+			return f, err
+		}
+	}
+}
+
+// createFile creates or truncates the file, applying the permissions.
+func (perms FilePermissions) createFile(fs FileSystem, filePath string) (File, error) {
+	f, err := fs.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perms.fileMode())
+	if err != nil {
+		return nil, err
+	}
+	if err = perms.apply(f, perms.FileMode); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// createTemp creates a new file in the directory, named by the prefix, a random
+// string and the suffix, applying the permissions. Unlike FileSystem.CreateTemp,
+// the default mode is the one of the other created files.
+func (perms FilePermissions) createTemp(fs FileSystem, dir, prefix, suffix string) (File, error) {
+	for i := 0; i < 10000; i++ {
+		filePath := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		f, err := fs.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, perms.fileMode())
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = perms.apply(f, perms.FileMode); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	return nil, &os.PathError{Op: "createtemp", Path: filepath.Join(dir, prefix+"*"+suffix), Err: os.ErrExist}
+}
+
+// mkdirAll creates the directory along with any missing parents, applying the
+// permissions to all the created directories.
+func (perms FilePermissions) mkdirAll(fs FileSystem, dirPath string) error {
+	stat, err := fs.Stat(dirPath)
+	if err == nil {
+		if !stat.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dirPath, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+
+	if parent := filepath.Dir(dirPath); parent != dirPath {
+		if err = perms.mkdirAll(fs, parent); err != nil {
+			return err
+		}
+	}
+
+	err = fs.Mkdir(dirPath, perms.dirMode())
+	if err != nil {
+		// Created by someone else in the meantime.
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	if perms.DirMode == 0 && !perms.Chown {
+		return nil
+	}
+
+	dir, err := fs.OpenFile(dirPath, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return perms.apply(dir, perms.DirMode)
+}
+
+func (perms FilePermissions) fileMode() os.FileMode {
+	if perms.FileMode == 0 {
+		return defaultFilePermissions
+	}
+	return perms.FileMode
+}
+
+func (perms FilePermissions) dirMode() os.FileMode {
+	if perms.DirMode == 0 {
+		return defaultDirectoryPermissions
+	}
+	return perms.DirMode
+}
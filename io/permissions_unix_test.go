@@ -0,0 +1,141 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+//go:build unix
+
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func checkPermissions(t *testing.T, path string, mode os.FileMode, gid int) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stat.Mode().Perm() != mode {
+		t.Errorf("%s: expected mode %v. Got: %v", path, mode, stat.Mode().Perm())
+	}
+	if owner := stat.Sys().(*syscall.Stat_t); int(owner.Gid) != gid {
+		t.Errorf("%s: expected group %d. Got: %d", path, gid, owner.Gid)
+	}
+}
+
+func TestRollingFileWriterPermissions(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize(filepath.Join("dir", "log.testlog"), RollingArchiveGzip, "", 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.HistoryDirPath = filepath.Join("dir", "history", "old")
+	// The group of the process is the only one a regular user can surely give files to.
+	writer.Permissions = FilePermissions{FileMode: 0640, DirMode: 0750, Chown: true, Uid: -1, Gid: os.Getgid()}
+
+	for i := 0; i < 3; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gid := os.Getgid()
+	checkPermissions(t, "dir", 0750, gid)
+	checkPermissions(t, filepath.Join("dir", "history"), 0750, gid)
+	checkPermissions(t, filepath.Join("dir", "history", "old"), 0750, gid)
+	checkPermissions(t, filepath.Join("dir", "log.testlog"), 0640, gid)
+	checkPermissions(t, filepath.Join("dir", "history", "old", "log.testlog.1.gz"), 0640, gid)
+}
+
+func TestRollingFileWriterPermissionsExistingFile(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	if err := os.WriteFile("log.testlog", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.Permissions = FilePermissions{FileMode: 0644}
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	checkPermissions(t, "log.testlog", 0600, os.Getgid())
+}
+
+func TestFileWriterPermissions(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewFileWriter(filepath.Join("dir", "sub", "log.testlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.SetPermissions(FilePermissions{FileMode: 0660, DirMode: 0770})
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+
+	gid := os.Getgid()
+	checkPermissions(t, "dir", 0770, gid)
+	checkPermissions(t, filepath.Join("dir", "sub"), 0770, gid)
+	checkPermissions(t, filepath.Join("dir", "sub", "log.testlog"), 0660, gid)
+}
+
+func TestRollingFileWriterPermissionsDefaultZip(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize(filepath.Join("dir", "log.testlog"), RollingArchiveZip, "", 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	for i := 0; i < 6; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The archive gets the same default mode as the log files.
+	stat, err := os.Stat(filepath.Join("dir", "log.testlog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPermissions(t, filepath.Join("dir", "log.zip"), stat.Mode().Perm(), os.Getgid())
+}
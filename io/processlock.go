@@ -0,0 +1,91 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var errProcessLockingFileSystem = errors.New("process locking works only with the OS file system")
+
+// processLockPath returns the path of the lock file used by ProcessLocking.
+func (rw *RollingFileWriter) processLockPath() string {
+	return filepath.Join(rw.CurrentDirPath, "."+rw.OriginalFileName+".lock")
+}
+
+// openProcessLockFile opens the lock file, creating it if needed. Locks are
+// placed on OS descriptors, so other file systems are refused.
+func (rw *RollingFileWriter) openProcessLockFile() (*os.File, error) {
+	if _, ok := rw.fs().(OSFileSystem); !ok {
+		return nil, errProcessLockingFileSystem
+	}
+	err := rw.Permissions.mkdirAll(defaultFileSystem, rw.CurrentDirPath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := rw.Permissions.openFile(defaultFileSystem, rw.processLockPath(), os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*os.File), nil
+}
+
+// lockProcesses places a shared or an exclusive advisory lock on the lock file,
+// converting the lock if it is already held.
+func (rw *RollingFileWriter) lockProcesses(exclusive bool) error {
+	if rw.processLockFile == nil {
+		f, err := rw.openProcessLockFile()
+		if err != nil {
+			return err
+		}
+		rw.processLockFile = f
+	}
+	return lockFile(rw.processLockFile, exclusive)
+}
+
+func (rw *RollingFileWriter) unlockProcesses() error {
+	return unlockFile(rw.processLockFile)
+}
+
+// lockProcessesExclusive places an exclusive lock on the lock file using its own
+// descriptor, so it can be used by the roll worker along with the writer.
+// The returned function releases the lock.
+func (rw *RollingFileWriter) lockProcessesExclusive() (unlock func() error, err error) {
+	f, err := rw.openProcessLockFile()
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f, true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		unlockFile(f)
+		return f.Close()
+	}, nil
+}
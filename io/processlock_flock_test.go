@@ -0,0 +1,202 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package io
+
+import (
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRollingFileWriterProcessLocking(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// Writers with their own lock file descriptors behave like separate processes.
+	const writersCount, writes = 4, 50
+	writers := make([]*RollingFileWriterSize, writersCount)
+	for i := range writers {
+		writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 100, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.ProcessLocking = true
+		writers[i] = writer
+	}
+
+	// Writes are interleaved, so every writer has to notice the writes and
+	// rolls of the others.
+	for i := 0; i < writes; i++ {
+		for _, writer := range writers {
+			if _, err := writer.Write(bytesFileTest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// And they must not break each other's rolls when running concurrently.
+	var wg sync.WaitGroup
+	for _, writer := range writers {
+		wg.Add(1)
+		go func(writer *RollingFileWriterSize) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if _, err := writer.Write(bytesFileTest); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(writer)
+	}
+	wg.Wait()
+	for _, writer := range writers {
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := writers[0].getSortedLogHistory("log.testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, f := range append(history, "log.testlog") {
+		stat, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A file is rolled when it is full, only the writes that were already
+		// checked under the shared lock may go over the limit.
+		if stat.Size() > 100+writersCount*messageLen {
+			t.Errorf("file %s was not rolled in time: %d bytes", f, stat.Size())
+		}
+		total += stat.Size()
+	}
+	if expected := int64(2 * writersCount * writes * messageLen); total != expected {
+		t.Errorf("expected %d bytes in %d files. Got: %d", expected, len(history)+1, total)
+	}
+}
+
+func TestRollingFileWriterProcessLockingBackgroundRoll(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveGzip, "", 1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.ProcessLocking = true
+	writer.BackgroundRoll = true
+	writer.BackgroundQueueSize = 1
+	writer.BackgroundErrorHandler = func(err error) {
+		t.Error(err)
+	}
+
+	// The roll queue fills up while the writes keep the process lock.
+	const goroutines, writes = 8, 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < writes; j++ {
+					if _, err := writer.Write(bytesFileTest); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if err := writer.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("writes with the process locking and the background roll are deadlocked")
+	}
+}
+
+func TestRollingFileWriterProcessLockingDiskSpacePrune(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	const writersCount, writes = 4, 20
+	writers := make([]*RollingFileWriterSize, writersCount)
+	for i := range writers {
+		writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveZip, "log.testlog.zip", 2*messageLen, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.ProcessLocking = true
+		writers[i] = writer
+	}
+	for i := 0; i < writes; i++ {
+		if _, err := writers[i%writersCount].Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The space is never enough, so every writer archives and prunes the whole
+	// history and the others must not see the rolls it is removing.
+	var wg sync.WaitGroup
+	for _, writer := range writers {
+		writer.DiskSpace = DiskSpaceGuard{MinFreeBytes: math.MaxInt64, Fallback: FallbackDrop}
+		wg.Add(1)
+		go func(writer *RollingFileWriterSize) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if _, err := writer.Write(bytesFileTest); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(writer)
+	}
+	wg.Wait()
+	for _, writer := range writers {
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := writers[0].getSortedLogHistory("log.testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("expected the history to be pruned. Got: %v", history)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// RollInfo describes the file passed to a roll hook.
+type RollInfo struct {
+	Path     string    // Path of the file
+	RollPath string    // Path of the roll that was archived into Path. Set for AfterArchive only
+	Size     int64     // Size of the file at Path in bytes
+	Start    time.Time // Time the writer opened the file of the roll. Zero if not known
+	End      time.Time // Time of the roll, or the last modification if it is not known
+}
+
+// RollHooks are called on the stages of a roll, e.g. to upload completed rolls
+// or to notify an indexer. A file continued from a previous run may hold data
+// older than RollInfo.Start.
+//
+// Hooks are called synchronously, so slow hooks delay the writes. They must not
+// use the writer. With BackgroundRoll, AfterArchive and AfterDelete are called
+// by the worker goroutine.
+type RollHooks struct {
+	BeforeRoll  func(RollInfo) // Before the current file at Path is closed for the roll
+	AfterRename func(RollInfo) // After the current file became the history file at Path
+
+	// AfterArchive is called after a roll was compressed into Path or added to
+	// the zip archive at Path.
+	AfterArchive func(RollInfo)
+
+	// AfterDelete is called after a history file was removed because of the
+	// retention limits, after it was put into the zip archive, or to free disk space.
+	AfterDelete func(RollInfo)
+}
+
+// currentRollInfo describes the current file for the hooks of its roll.
+func (rw *RollingFileWriter) currentRollInfo() RollInfo {
+	return RollInfo{
+		Path:  filepath.Join(rw.CurrentDirPath, rw.FileName),
+		Size:  rw.CurrentFileSize,
+		Start: rw.currentFileStart,
+		End:   rw.clock().Now(),
+	}
+}
+
+// statRollInfo describes the existing file by its stat. Returns false if the
+// file does not exist.
+func (rw *RollingFileWriter) statRollInfo(path string) (RollInfo, bool) {
+	stat, err := rw.fs().Stat(path)
+	if err != nil {
+		return RollInfo{}, false
+	}
+	return RollInfo{Path: path, Size: stat.Size(), End: stat.ModTime()}, true
+}
+
+// archivedRollInfo describes the archive at archivePath the roll was put into.
+func (rw *RollingFileWriter) archivedRollInfo(archivePath string, roll RollInfo) RollInfo {
+	info := RollInfo{Path: archivePath, RollPath: roll.Path, Start: roll.Start, End: roll.End}
+	if stat, err := rw.fs().Stat(archivePath); err == nil {
+		info.Size = stat.Size()
+	}
+	return info
+}
+
+// removeRoll removes the history file, calling the AfterDelete hook if it existed.
+func (rw *RollingFileWriter) removeRoll(rollPath string) error {
+	if rw.Hooks.AfterDelete == nil {
+		return tryRemoveFile(rw.fs(), rollPath)
+	}
+
+	info, ok := rw.statRollInfo(rollPath)
+	err := tryRemoveFile(rw.fs(), rollPath)
+	if err == nil && ok {
+		rw.Hooks.AfterDelete(info)
+	}
+	return err
+}
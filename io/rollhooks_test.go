@@ -0,0 +1,187 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hooksRecorder records the hook calls as "hook path size" and uploads the
+// archived rolls to a bucket, like an object storage uploader would.
+type hooksRecorder struct {
+	fs     *MemFileSystem
+	mutex  sync.Mutex
+	calls  []string
+	rolls  []RollInfo
+	bucket map[string][]byte
+}
+
+func newHooksRecorder(fs *MemFileSystem) *hooksRecorder {
+	return &hooksRecorder{fs: fs, bucket: make(map[string][]byte)}
+}
+
+func (recorder *hooksRecorder) record(hook string) func(RollInfo) {
+	return func(info RollInfo) {
+		recorder.mutex.Lock()
+		defer recorder.mutex.Unlock()
+
+		call := fmt.Sprintf("%s %s %d", hook, info.Path, info.Size)
+		if len(info.RollPath) != 0 {
+			call += " " + info.RollPath
+		}
+		recorder.calls = append(recorder.calls, call)
+		recorder.rolls = append(recorder.rolls, info)
+		if hook == "archive" {
+			data, err := recorder.fs.ReadFile(info.Path)
+			if err == nil {
+				recorder.bucket[filepath.Base(info.RollPath)] = data
+			}
+		}
+	}
+}
+
+func (recorder *hooksRecorder) hooks() RollHooks {
+	return RollHooks{
+		BeforeRoll:   recorder.record("before"),
+		AfterRename:  recorder.record("rename"),
+		AfterArchive: recorder.record("archive"),
+		AfterDelete:  recorder.record("delete"),
+	}
+}
+
+func (recorder *hooksRecorder) check(t *testing.T, expected ...string) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if len(recorder.calls) != len(expected) {
+		t.Fatalf("expected hook calls:\n%q\nGot:\n%q", expected, recorder.calls)
+	}
+	for i := range expected {
+		if recorder.calls[i] != expected[i] {
+			t.Fatalf("expected hook calls:\n%q\nGot:\n%q", expected, recorder.calls)
+		}
+	}
+}
+
+func TestRollHooks(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	fs := NewMemFileSystem()
+	recorder := newHooksRecorder(fs)
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 1)
+	writer.Clock = clock
+	writer.Hooks = recorder.hooks()
+	defer writer.Close()
+
+	writeMessages(t, writer, 2)
+	clock.Advance(time.Minute)
+	writeMessages(t, writer, 3)
+
+	path := filepath.Join("logs", "log.testlog")
+	recorder.check(t,
+		"before "+path+" 20",
+		"rename "+path+".1 20",
+		"before "+path+" 20",
+		"rename "+path+".2 20",
+		"delete "+path+".1 20",
+	)
+
+	first := recorder.rolls[1]
+	if !first.Start.Equal(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)) || !first.End.Equal(clock.Now()) {
+		t.Errorf("unexpected time range of the roll: %v - %v", first.Start, first.End)
+	}
+	if second := recorder.rolls[3]; !second.Start.Equal(clock.Now()) {
+		t.Errorf("unexpected start of the second roll: %v", second.Start)
+	}
+}
+
+func TestRollHooksGzip(t *testing.T) {
+	fs := NewMemFileSystem()
+	recorder := newHooksRecorder(fs)
+	writer := newMemSizeWriter(t, fs, RollingArchiveGzip, 0)
+	writer.Hooks = recorder.hooks()
+	defer writer.Close()
+
+	writeMessages(t, writer, 3)
+
+	path := filepath.Join("logs", "log.testlog")
+	gzPath := path + ".1.gz"
+	data, err := fs.ReadFile(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.check(t,
+		"before "+path+" 20",
+		"rename "+path+".1 20",
+		fmt.Sprintf("archive %s %d %s.1", gzPath, len(data), path),
+	)
+	if string(recorder.bucket["log.testlog.1"]) != string(data) {
+		t.Error("the archived roll was not uploaded")
+	}
+}
+
+func TestRollHooksZipBackground(t *testing.T) {
+	fs := NewMemFileSystem()
+	recorder := newHooksRecorder(fs)
+	writer := newMemSizeWriter(t, fs, RollingArchiveZip, 1)
+	writer.Hooks = recorder.hooks()
+	writer.BackgroundRoll = true
+
+	writeMessages(t, writer, 5)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join("logs", "log.testlog")
+	zipPath := filepath.Join("logs", "log.zip")
+	zipStat, err := fs.Stat(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.check(t,
+		"before "+path+" 20",
+		"rename "+path+".1 20",
+		"before "+path+" 20",
+		"rename "+path+".2 20",
+		fmt.Sprintf("archive %s %d %s.1", zipPath, zipStat.Size(), path),
+		"delete "+path+".1 20",
+	)
+}
+
+func TestRollHooksDiskSpacePrune(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.Capacity = 100
+	recorder := newHooksRecorder(fs)
+	writer := newMemSizeWriter(t, fs, RollingArchiveNone, 0)
+	writer.DiskSpace = DiskSpaceGuard{MinFreeBytes: 50}
+	writer.Hooks = RollHooks{AfterDelete: recorder.record("delete")}
+	defer writer.Close()
+
+	writeMessages(t, writer, 7)
+	recorder.check(t, "delete "+filepath.Join("logs", "log.testlog.1")+" 20")
+}
@@ -25,13 +25,14 @@
 package io
 
 import (
+	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -46,6 +47,7 @@ type RollingType uint8
 const (
 	RollingTypeSize = iota
 	RollingTypeTime
+	RollingTypeSizeTime
 )
 
 type RollingIntervalType uint8
@@ -53,16 +55,24 @@ type RollingIntervalType uint8
 const (
 	RollingIntervalAny = iota
 	RollingIntervalDaily
+	RollingIntervalHourly
+	RollingIntervalWeekly
+	RollingIntervalMonthly
+	RollingIntervalDuration // Custom interval set by RollingFileWriterTime.IntervalDuration
 )
 
-// File and directory permitions.
+// File and directory permitions used if FilePermissions does not set them.
 const (
 	defaultFilePermissions      = 0666
-	defaultDirectoryPermissions = 0767
+	defaultDirectoryPermissions = 0755
 )
 
 var rollingInvervalTypesStringRepresentation = map[RollingIntervalType]string{
-	RollingIntervalDaily: "daily",
+	RollingIntervalDaily:    "daily",
+	RollingIntervalHourly:   "hourly",
+	RollingIntervalWeekly:   "weekly",
+	RollingIntervalMonthly:  "monthly",
+	RollingIntervalDuration: "duration",
 }
 
 func RollingIntervalTypeFromString(RollingTypeStr string) (RollingIntervalType, bool) {
@@ -76,8 +86,9 @@ func RollingIntervalTypeFromString(RollingTypeStr string) (RollingIntervalType,
 }
 
 var RollingTypesStringRepresentation = map[RollingType]string{
-	RollingTypeSize: "size",
-	RollingTypeTime: "date",
+	RollingTypeSize:     "size",
+	RollingTypeTime:     "date",
+	RollingTypeSizeTime: "sizedate",
 }
 
 func RollingTypeFromString(RollingTypeStr string) (RollingType, bool) {
@@ -96,11 +107,13 @@ type RollingArchiveType uint8
 const (
 	RollingArchiveNone = iota
 	RollingArchiveZip
+	RollingArchiveGzip
 )
 
 var RollingArchiveTypesStringRepresentation = map[RollingArchiveType]string{
 	RollingArchiveNone: "none",
 	RollingArchiveZip:  "zip",
+	RollingArchiveGzip: "gzip",
 }
 
 func RollingArchiveTypeFromString(RollingArchiveTypeStr string) (RollingArchiveType, bool) {
@@ -118,6 +131,23 @@ var RollingArchiveTypesDefaultNames = map[RollingArchiveType]string{
 	RollingArchiveZip: "log.zip",
 }
 
+// File name suffixes for archivation types that compress every roll separately.
+var rollingArchiveTypesFileSuffixes = map[RollingArchiveType]string{
+	RollingArchiveGzip: ".gz",
+}
+
+// trimArchiveSuffix removes a known compression suffix from the roll file name.
+// All suffixes are recognized regardless of the current archive type, so history
+// written with a different configuration is still accounted for.
+func trimArchiveSuffix(fileName string) string {
+	for _, suffix := range rollingArchiveTypesFileSuffixes {
+		if strings.HasSuffix(fileName, suffix) {
+			return fileName[:len(fileName)-len(suffix)]
+		}
+	}
+	return fileName
+}
+
 // RollerVirtual is an interface that represents all virtual funcs that are
 // called in different rolling writer subtypes.
 type RollerVirtual interface {
@@ -136,17 +166,108 @@ type RollerVirtual interface {
 // and writer starts to log into a new file. You can set a limit for such renamed
 // files count, if you want, and then the rolling writer would delete older ones when
 // the files count exceed the specified limit.
+//
+// RollingFileWriter is safe for concurrent use by multiple goroutines.
 type RollingFileWriter struct {
 	FileName         string // current file name. May differ from original in date rolling loggers
 	OriginalFileName string // original one
 	CurrentDirPath   string
-	CurrentFile      *os.File
+	CurrentFile      File
 	CurrentFileSize  int64
 	RollingType      RollingType // Rolling mode (Files roll by size/date/...)
 	ArchiveType      RollingArchiveType
-	ArchivePath      string
 	MaxRolls         int
+	MaxAge           time.Duration // Rolls modified earlier than MaxAge ago are removed. 0 - no limit
+	MaxHistorySize   int64         // Max total size of rolls in bytes, older ones are removed. 0 - no limit
 	Self             RollerVirtual // Used for virtual calls
+
+	// ArchivePath is the zip archive old rolls are moved to. Every addition
+	// rewrites the whole archive into a temporary file, so that a crash never
+	// corrupts it, and the cost of a roll grows with the archive. Limit it with
+	// MaxArchiveSize, or use RollingArchiveGzip, if the history is large.
+	ArchivePath string
+
+	// MaxArchiveSize is the size in bytes after which the zip archive is renamed
+	// to a numbered one, e.g. "log.1.zip", and a new archive is started. 0 - no limit
+	MaxArchiveSize int64
+
+	Namer           RollingNamer // File naming strategy. SuffixRollingNamer with "." delimiter if nil
+	HistoryDirPath  string       // Directory rolls are moved to. CurrentDirPath if empty
+	CurrentLinkName string       // Name of a symlink in CurrentDirPath to the active file. No link if empty
+
+	// If set, Write checks at most once per StatCheckInterval that the current
+	// file was not deleted, replaced or truncated by someone else. Missing and
+	// replaced files are recreated, the size of a truncated file is synchronized.
+	StatCheckInterval time.Duration
+
+	// ProcessLocking makes rolling safe for several processes writing to the same
+	// file. An advisory lock on a lock file next to the log is held shared during
+	// writes and exclusive during rolls and history scans, so exactly one process
+	// performs each roll and the others reopen the new file.
+	ProcessLocking bool
+
+	// RecordDelimiter ends every record, e.g. "\n" for lines. If it is set, rolls
+	// happen only between whole records, so a record split into several writes
+	// is never spread over two files. No framing if empty.
+	RecordDelimiter []byte
+
+	// Permissions of the created files and directories.
+	Permissions FilePermissions
+
+	// Durability sets how often written data is synced to the disk. Unless it is
+	// SyncNever, the file and the directories are also synced on rolls.
+	Durability SyncPolicy
+
+	// FileSystem all files and directories are accessed through. The file system
+	// of the OS if nil. ProcessLocking works only with the OS file system.
+	FileSystem FileSystem
+
+	// Clock time rolling, MaxAge and StatCheckInterval are measured by. SystemClock if nil.
+	Clock Clock
+
+	// DiskSpace keeps the writer from filling up the volume. The file system
+	// must be a DiskSpaceReporter to use it.
+	DiskSpace DiskSpaceGuard
+
+	// Hooks are called on the stages of rolls.
+	Hooks RollHooks
+
+	// Header returns the data every new file starts with, e.g. W3CHeader. Files
+	// that already have data are continued without a header. No header if nil.
+	// The header and the footer count toward the size of the file.
+	Header func(filePath string, start time.Time) []byte
+
+	// Footer returns the data a file ends with when it is rolled, e.g. W3CFooter.
+	// Files are not ended on Close, since they may be continued. No footer if nil.
+	Footer func(FileSummary) []byte
+
+	// Background rolling: only the rename of the current file is done in Write,
+	// while compression and removal of old rolls are done by a worker goroutine.
+	// Errors of the worker are passed to BackgroundErrorHandler, or, if it is nil,
+	// sent to BackgroundErrors without blocking. Close waits for pending work.
+	BackgroundRoll         bool
+	BackgroundQueueSize    int // Max number of pending rolls. Write blocks when the queue is full, or processes it with ProcessLocking
+	BackgroundErrorHandler func(error)
+	BackgroundErrors       chan error
+
+	mutex             *sync.Mutex // Guards the current file state, so the writer can be shared by goroutines
+	historyMutex      *sync.Mutex // Serializes history updates of the roll worker and the disk space guard
+	processLockFile   *os.File
+	worker            *rollWorker
+	stateRestored     bool   // Files left by previous runs were checked on startup
+	resumedFileName   string // File of a previous run to continue writing to, see restoreState
+	lastRollTail      string // Tail of the latest roll, valid if lastRollTailKnown is set
+	lastRollTailKnown bool
+	lastStatCheck     time.Time
+	currentFileStart  time.Time   // Time the current file was opened, see RollInfo.Start
+	summary           FileSummary // Writes to the current file for the footer
+	syncer            fileSyncer
+	recordOpen        bool // The last write did not end a record
+
+	diskSpaceMode      DiskSpaceMode
+	diskSpaceChecked   bool
+	lastDiskSpaceCheck time.Time
+	fallbackFile       File // Open file of FallbackDirectory mode
 }
 
 func NewRollingFileWriter(fpath string, rtype RollingType, atype RollingArchiveType, apath string, maxr int) (*RollingFileWriter, error) {
@@ -157,25 +278,69 @@ func NewRollingFileWriter(fpath string, rtype RollingType, atype RollingArchiveT
 	}
 	rw.OriginalFileName = rw.FileName
 
+	// Archives without an explicit path are kept next to the log files.
+	if len(apath) == 0 && len(RollingArchiveTypesDefaultNames[atype]) != 0 {
+		apath = filepath.Join(rw.CurrentDirPath, RollingArchiveTypesDefaultNames[atype])
+	}
+
 	rw.RollingType = rtype
 	rw.ArchiveType = atype
 	rw.ArchivePath = apath
 	rw.MaxRolls = maxr
+	rw.mutex = new(sync.Mutex)
+	rw.historyMutex = new(sync.Mutex)
 	return rw, nil
 }
 
-func (rw *RollingFileWriter) getSortedLogHistory() ([]string, error) {
-	files, err := getDirFilePaths(rw.CurrentDirPath, nil, true)
+func (rw *RollingFileWriter) namer() RollingNamer {
+	if rw.Namer == nil {
+		return defaultRollingNamer
+	}
+	return rw.Namer
+}
+
+func (rw *RollingFileWriter) fs() FileSystem {
+	if rw.FileSystem == nil {
+		return defaultFileSystem
+	}
+	return rw.FileSystem
+}
+
+func (rw *RollingFileWriter) clock() Clock {
+	if rw.Clock == nil {
+		return defaultClock
+	}
+	return rw.Clock
+}
+
+func (rw *RollingFileWriter) historyDirPath() string {
+	if len(rw.HistoryDirPath) == 0 {
+		return rw.CurrentDirPath
+	}
+	return rw.HistoryDirPath
+}
+
+// getSortedLogHistory returns the names of the history files in the history
+// directory sorted by their creation time. The file named currentFileName is skipped.
+func (rw *RollingFileWriter) getSortedLogHistory(currentFileName string) ([]string, error) {
+	files, err := getDirFilePaths(rw.fs(), rw.historyDirPath(), nil, true)
 	if err != nil {
 		return nil, err
 	}
-	pref := rw.OriginalFileName + rollingLogHistoryDelimiter
 	var validFileTails []string
+	// Compressed and not yet compressed rolls share the same tail.
+	tailFiles := make(map[string][]string)
 	for _, file := range files {
-		if file != rw.FileName && strings.HasPrefix(file, pref) {
-			tail := rw.getFileTail(file)
+		if file == currentFileName {
+			continue
+		}
+		tail, ok := rw.namer().FileTail(rw.OriginalFileName, trimArchiveSuffix(file))
+		if ok && len(tail) != 0 {
 			if rw.Self.isFileTailValid(tail) {
-				validFileTails = append(validFileTails, tail)
+				if _, ok := tailFiles[tail]; !ok {
+					validFileTails = append(validFileTails, tail)
+				}
+				tailFiles[tail] = append(tailFiles[tail], file)
 			}
 		}
 	}
@@ -183,9 +348,9 @@ func (rw *RollingFileWriter) getSortedLogHistory() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	validSortedFiles := make([]string, len(sortedTails))
-	for i, v := range sortedTails {
-		validSortedFiles[i] = rw.OriginalFileName + rollingLogHistoryDelimiter + v
+	var validSortedFiles []string
+	for _, v := range sortedTails {
+		validSortedFiles = append(validSortedFiles, tailFiles[v]...)
 	}
 	return validSortedFiles, nil
 }
@@ -193,53 +358,156 @@ func (rw *RollingFileWriter) getSortedLogHistory() ([]string, error) {
 func (rw *RollingFileWriter) createFileAndFolderIfNeeded() error {
 	var err error
 
-	if len(rw.CurrentDirPath) != 0 {
-		err = os.MkdirAll(rw.CurrentDirPath, defaultDirectoryPermissions)
+	for _, dirPath := range []string{rw.CurrentDirPath, rw.HistoryDirPath} {
+		if len(dirPath) != 0 {
+			err = rw.Permissions.mkdirAll(rw.fs(), dirPath)
 
-		if err != nil {
-			return err
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	rw.FileName = rw.Self.getCurrentModifiedFileName(rw.OriginalFileName)
+	if len(rw.resumedFileName) != 0 {
+		rw.FileName = rw.resumedFileName
+		rw.resumedFileName = ""
+	} else {
+		rw.FileName = rw.Self.getCurrentModifiedFileName(rw.OriginalFileName)
+	}
 	filePath := filepath.Join(rw.CurrentDirPath, rw.FileName)
 
-	// If exists
-	stat, err := os.Lstat(filePath)
-	if err == nil {
-		rw.CurrentFile, err = os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, defaultFilePermissions)
+	// An existing file is never truncated: it may be written by another process.
+	rw.CurrentFile, err = rw.Permissions.openFile(rw.fs(), filePath, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+
+	stat, err := rw.CurrentFile.Stat()
+	if err != nil {
+		return err
+	}
+	rw.CurrentFileSize = stat.Size()
+	rw.lastStatCheck = rw.clock().Now()
+	rw.currentFileStart = rw.lastStatCheck
+	rw.summary = FileSummary{}
 
-		stat, err = os.Lstat(filePath)
+	if rw.Header != nil && rw.CurrentFileSize == 0 {
+		err = rw.writeHeader()
 		if err != nil {
 			return err
 		}
-
-		rw.CurrentFileSize = stat.Size()
-	} else {
-		rw.CurrentFile, err = os.Create(filePath)
-		rw.CurrentFileSize = 0
 	}
+	return rw.updateCurrentLink()
+}
+
+// checkCurrentFile makes sure that the open file is still the one on disk. A file
+// that was deleted or replaced is reopened by name, and the size of a truncated
+// file is synchronized.
+func (rw *RollingFileWriter) checkCurrentFile() error {
+	rw.lastStatCheck = rw.clock().Now()
+
+	openStat, err := rw.CurrentFile.Stat()
 	if err != nil {
 		return err
 	}
+	pathStat, err := rw.fs().Stat(filepath.Join(rw.CurrentDirPath, rw.FileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 
+	if err != nil || !rw.fs().SameFile(openStat, pathStat) {
+		// Writes to the unlinked file would never be seen, so they are lost
+		// only until the file is recreated.
+		rw.syncer.finish(rw.CurrentFile, SyncPolicy{})
+		rw.CurrentFile.Close()
+		rw.CurrentFile = nil
+		return rw.createFileAndFolderIfNeeded()
+	}
+
+	rw.CurrentFileSize = openStat.Size()
 	return nil
 }
 
-func (rw *RollingFileWriter) deleteOldRolls(history []string) error {
-	if rw.MaxRolls <= 0 {
+// updateCurrentLink points the CurrentLinkName symlink to the current file.
+// The link is replaced atomically, so readers always find one.
+func (rw *RollingFileWriter) updateCurrentLink() error {
+	if len(rw.CurrentLinkName) == 0 || rw.CurrentLinkName == rw.FileName {
 		return nil
 	}
 
-	rollsToDelete := len(history) - rw.MaxRolls
+	linkPath := filepath.Join(rw.CurrentDirPath, rw.CurrentLinkName)
+	fs := rw.fs()
+	if target, err := fs.Readlink(linkPath); err == nil && target == rw.FileName {
+		return nil
+	}
+
+	tmpPath := fmt.Sprintf("%s.%d.tmp", linkPath, os.Getpid())
+	err := tryRemoveFile(fs, tmpPath)
+	if err != nil {
+		return err
+	}
+	err = fs.Symlink(rw.FileName, tmpPath)
+	if err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, linkPath)
+}
+
+// deleteOldRolls removes (or archives) the oldest rolls of the sorted history
+// that are out of the retention limits: MaxRolls, MaxAge and MaxHistorySize.
+func (rw *RollingFileWriter) deleteOldRolls(history []string) error {
+	rollsToDelete := 0
+	if rw.MaxRolls > 0 && len(history) > rw.MaxRolls {
+		rollsToDelete = len(history) - rw.MaxRolls
+	}
+	if rw.MaxAge > 0 || rw.MaxHistorySize > 0 {
+		if n := rw.getRollsOutOfRetention(history); n > rollsToDelete {
+			rollsToDelete = n
+		}
+	}
 	if rollsToDelete <= 0 {
 		return nil
 	}
 
-	// In all cases (archive files or not) the files should be deleted.
+	rollPaths := make([]string, rollsToDelete)
 	for i := 0; i < rollsToDelete; i++ {
-		rollPath := filepath.Join(rw.CurrentDirPath, history[i])
-		err := tryRemoveFile(rollPath)
+		rollPaths[i] = filepath.Join(rw.historyDirPath(), history[i])
+	}
+	return rw.removeRolls(rollPaths)
+}
+
+// removeRolls removes the history files, putting them into the zip archive
+// first if the archive type is RollingArchiveZip.
+func (rw *RollingFileWriter) removeRolls(rollPaths []string) error {
+	// Old rolls are put into the archive before they are removed, so that
+	// a failed archivation never loses them.
+	if rw.ArchiveType == RollingArchiveZip {
+		var rolls []RollInfo
+		if rw.Hooks.AfterArchive != nil {
+			for _, rollPath := range rollPaths {
+				if roll, ok := rw.statRollInfo(rollPath); ok {
+					rolls = append(rolls, roll)
+				}
+			}
+		}
+		err := addFilesToZip(rw.ArchivePath, rollPaths, rw.archiveOptions())
+		if err != nil {
+			return err
+		}
+		for _, roll := range rolls {
+			rw.Hooks.AfterArchive(rw.archivedRollInfo(rw.ArchivePath, roll))
+		}
+		if rw.MaxArchiveSize > 0 {
+			err = rw.splitArchive()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// In all cases (archive files or not) the files should be deleted.
+	for _, rollPath := range rollPaths {
+		err := rw.removeRoll(rollPath)
 		if err != nil {
 			return err
 		}
@@ -248,10 +516,99 @@ func (rw *RollingFileWriter) deleteOldRolls(history []string) error {
 	return nil
 }
 
+// getRollsOutOfRetention returns the number of the oldest history files to be
+// removed because they are older than MaxAge or do not fit into MaxHistorySize.
+// History is always cut from its oldest end, so a file is kept only if all the
+// newer ones are kept as well.
+func (rw *RollingFileWriter) getRollsOutOfRetention(history []string) int {
+	minModTime := rw.clock().Now().Add(-rw.MaxAge)
+	var totalSize int64
+	for i := len(history) - 1; i >= 0; i-- {
+		stat, err := rw.fs().Stat(filepath.Join(rw.historyDirPath(), history[i]))
+		if err != nil {
+			// Already removed.
+			continue
+		}
+		totalSize += stat.Size()
+		if rw.MaxHistorySize > 0 && totalSize > rw.MaxHistorySize {
+			return i + 1
+		}
+		if rw.MaxAge > 0 && stat.ModTime().Before(minModTime) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// applyRetention removes the rolls that are out of the retention limits
+// without rolling the current file.
+func (rw *RollingFileWriter) applyRetention() error {
+	history, err := rw.getSortedLogHistory(rw.currentHistoryFileName())
+	if err != nil {
+		return err
+	}
+	return rw.deleteOldRolls(history)
+}
+
+// compressRoll compresses the history file if the archive type requires it and
+// returns the name of the resulting file. The roll describes the file for the
+// AfterArchive hook, it is taken from the file itself if its Path is empty.
+func (rw *RollingFileWriter) compressRoll(historyName string, roll RollInfo) (string, error) {
+	suffix, ok := rollingArchiveTypesFileSuffixes[rw.ArchiveType]
+	if !ok {
+		return historyName, nil
+	}
+
+	rollPath := filepath.Join(rw.historyDirPath(), historyName)
+	if rw.Hooks.AfterArchive != nil && len(roll.Path) == 0 {
+		roll, _ = rw.statRollInfo(rollPath)
+	}
+	roll.Path = rollPath
+
+	err := gzipFile(rollPath, rollPath+suffix, rw.archiveOptions())
+	if err != nil {
+		return "", err
+	}
+	if rw.Hooks.AfterArchive != nil {
+		rw.Hooks.AfterArchive(rw.archivedRollInfo(rollPath+suffix, roll))
+	}
+	return historyName + suffix, nil
+}
+
+// splitArchive renames the zip archive to the first free numbered name once it
+// reaches MaxArchiveSize, so the next rolls start a new one.
+func (rw *RollingFileWriter) splitArchive() error {
+	stat, err := rw.fs().Stat(rw.ArchivePath)
+	if err != nil || stat.Size() < rw.MaxArchiveSize {
+		return err
+	}
+
+	ext := filepath.Ext(rw.ArchivePath)
+	base := strings.TrimSuffix(rw.ArchivePath, ext)
+	for i := 1; ; i++ {
+		splitPath := base + rollingLogHistoryDelimiter + strconv.Itoa(i) + ext
+		if _, err = rw.fs().Lstat(splitPath); !os.IsNotExist(err) {
+			continue
+		}
+		err = rw.fs().Rename(rw.ArchivePath, splitPath)
+		if err != nil {
+			return err
+		}
+		if rw.Durability.durable() {
+			return syncDir(rw.fs(), filepath.Dir(rw.ArchivePath))
+		}
+		return nil
+	}
+}
+
+func (rw *RollingFileWriter) archiveOptions() archiveOptions {
+	return archiveOptions{fs: rw.fs(), durable: rw.Durability.durable(), perms: rw.Permissions}
+}
+
 // tryRemoveFile gives a try removing the file
 // only ignoring an error when the file does not exist.
-func tryRemoveFile(filePath string) (err error) {
-	err = os.Remove(filePath)
+func tryRemoveFile(fs FileSystem, filePath string) (err error) {
+	err = fs.Remove(filePath)
 	if os.IsNotExist(err) {
 		err = nil
 		return
@@ -259,110 +616,453 @@ func tryRemoveFile(filePath string) (err error) {
 	return
 }
 
+// getFileTail returns the tail of the file name, trimming a compression suffix.
 func (rw *RollingFileWriter) getFileTail(FileName string) string {
-	return FileName[len(rw.OriginalFileName+rollingLogHistoryDelimiter):]
+	tail, _ := rw.namer().FileTail(rw.OriginalFileName, trimArchiveSuffix(FileName))
+	return tail
 }
 
-func (rw *RollingFileWriter) Write(bytes []byte) (n int, err error) {
+// currentHistoryFileName returns the name of the current file as it should be
+// skipped in the history scans. Empty if the history is kept in another directory.
+func (rw *RollingFileWriter) currentHistoryFileName() string {
+	if rw.historyDirPath() != rw.CurrentDirPath {
+		return ""
+	}
+	return rw.FileName
+}
+
+func (rw *RollingFileWriter) Write(data []byte) (n int, err error) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	// Other processes can not roll the file while it is being written to.
+	if rw.ProcessLocking {
+		err = rw.lockProcesses(false)
+		if err != nil {
+			return 0, err
+		}
+		defer rw.unlockProcesses()
+	}
+
 	if rw.CurrentFile == nil {
-		err := rw.createFileAndFolderIfNeeded()
+		err = rw.openCurrentFile()
+		if err != nil {
+			return 0, err
+		}
+	} else if rw.ProcessLocking || (rw.StatCheckInterval > 0 && rw.clock().Now().Sub(rw.lastStatCheck) >= rw.StatCheckInterval) {
+		// With process locking the file may be rolled by another process.
+		err = rw.checkCurrentFile()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if rw.DiskSpace.enabled() && !rw.recordOpen {
+		err = rw.checkDiskSpace()
 		if err != nil {
 			return 0, err
 		}
 	}
+	if rw.diskSpaceMode == DiskSpaceLow {
+		n, err = rw.writeFallback(data)
+		rw.recordWritten(data[:n])
+		return n, err
+	}
+
 	// needs to roll if:
 	//   * file roller max file size exceeded OR
 	//   * time roller Interval passed
-	nr, err := rw.Self.needsToRoll()
-	if err != nil {
-		return 0, err
+	// and the previous write did not leave a record unfinished.
+	nr := false
+	if !rw.recordOpen {
+		nr, err = rw.Self.needsToRoll()
+		if err != nil {
+			return 0, err
+		}
 	}
 	if nr {
-		// First, close current file.
-		err = rw.CurrentFile.Close()
+		if rw.ProcessLocking {
+			err = rw.rollExclusive()
+		} else {
+			err = rw.roll()
+		}
 		if err != nil {
 			return 0, err
 		}
+	}
+
+	rw.CurrentFileSize += int64(len(data))
+	n, err = rw.CurrentFile.Write(data)
+	rw.recordWritten(data[:n])
+	rw.summary.add(data[:n], rw.RecordDelimiter)
+	if err != nil {
+		return n, err
+	}
+	return n, rw.syncer.written(rw.CurrentFile, n, rw.Durability, rw.syncLater)
+}
+
+// recordWritten remembers whether the written data left a record unfinished.
+func (rw *RollingFileWriter) recordWritten(written []byte) {
+	if len(rw.RecordDelimiter) != 0 && len(written) > 0 {
+		rw.recordOpen = !bytes.HasSuffix(written, rw.RecordDelimiter)
+	}
+}
+
+// syncLater is called by the timer of SyncInterval durability mode.
+func (rw *RollingFileWriter) syncLater() {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	rw.syncer.timerFired(rw.CurrentFile)
+}
+
+// Sync commits the data written to the current file to the disk regardless of
+// the durability policy.
+func (rw *RollingFileWriter) Sync() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
 
-		// Current history of all previous log files.
-		// For file roller it may be like this:
-		//     * ...
-		//     * file.log.4
-		//     * file.log.5
-		//     * file.log.6
-		//
-		// For date roller it may look like this:
-		//     * ...
-		//     * file.log.11.Aug.13
-		//     * file.log.15.Aug.13
-		//     * file.log.16.Aug.13
-		// Sorted log history does NOT include current file.
-		history, err := rw.getSortedLogHistory()
+	if rw.CurrentFile == nil {
+		return nil
+	}
+	if err := rw.syncer.takeError(); err != nil {
+		return err
+	}
+	if err := rw.CurrentFile.Sync(); err != nil {
+		return err
+	}
+	rw.syncer.unsynced = 0
+	return nil
+}
+
+// openCurrentFile opens the current file. On the first call files left by
+// previous runs are checked.
+func (rw *RollingFileWriter) openCurrentFile() error {
+	if rw.stateRestored {
+		return rw.createFileAndFolderIfNeeded()
+	}
+
+	// Startup changes the shared history.
+	if rw.ProcessLocking {
+		err := rw.lockProcesses(true)
 		if err != nil {
-			return 0, err
+			return err
+		}
+		defer rw.lockProcesses(false)
+	}
+
+	err := rw.restoreState()
+	if err != nil {
+		return err
+	}
+	err = rw.createFileAndFolderIfNeeded()
+	if err != nil {
+		return err
+	}
+	err = rw.applyRetention()
+	if err != nil {
+		return err
+	}
+	rw.stateRestored = true
+	return nil
+}
+
+// rollExclusive performs the roll holding the exclusive process lock. If another
+// process rolled the file while the lock was being acquired, the writer just
+// follows it to the new file.
+func (rw *RollingFileWriter) rollExclusive() error {
+	err := rw.lockProcesses(true)
+	if err != nil {
+		return err
+	}
+
+	err = rw.checkCurrentFile()
+	if err != nil {
+		rw.lockProcesses(false)
+		return err
+	}
+	nr, err := rw.Self.needsToRoll()
+	if err == nil && nr {
+		// The history is shared, so it is always scanned.
+		rw.lastRollTailKnown = false
+		err = rw.roll()
+	}
+	if err != nil {
+		rw.lockProcesses(false)
+		return err
+	}
+
+	// The lock is not converted atomically: someone may have rolled in between.
+	err = rw.lockProcesses(false)
+	if err != nil {
+		return err
+	}
+	return rw.checkCurrentFile()
+}
+
+func (rw *RollingFileWriter) roll() error {
+	if rw.Footer != nil {
+		err := rw.writeFooter()
+		if err != nil {
+			return err
 		}
+	}
+	roll := rw.currentRollInfo()
+	if rw.Hooks.BeforeRoll != nil {
+		rw.Hooks.BeforeRoll(roll)
+	}
 
-		// Renames current file to create a new roll history entry
-		// For file roller it may be like this:
-		//     * ...
-		//     * file.log.4
-		//     * file.log.5
-		//     * file.log.6
-		//     n file.log.7  <---- RENAMED (from file.log)
-		// Time rollers that doesn't modify file names (e.g. 'date' roller) skip this logic.
-		var newHistoryName string
-		var newTail string
+	// First, close current file. It is synced before, so that the renamed
+	// history file is complete on the disk.
+	err := rw.syncer.finish(rw.CurrentFile, rw.Durability)
+	if err != nil {
+		return err
+	}
+	err = rw.CurrentFile.Close()
+	if err != nil {
+		return err
+	}
+	// If the roll fails, the next write opens the current file again.
+	rw.CurrentFile = nil
+
+	// Current history of all previous log files.
+	// For file roller it may be like this:
+	//     * ...
+	//     * file.log.4
+	//     * file.log.5
+	//     * file.log.6
+	//
+	// For date roller it may look like this:
+	//     * ...
+	//     * file.log.11.Aug.13
+	//     * file.log.15.Aug.13
+	//     * file.log.16.Aug.13
+	// Sorted log history does NOT include current file.
+	//
+	// With background rolling the history is scanned only once: after that the
+	// writer knows the last roll tail itself, and the scan is done by the worker.
+	var history []string
+	if !rw.BackgroundRoll || !rw.lastRollTailKnown {
+		history, err = rw.getSortedLogHistory(rw.currentHistoryFileName())
+		if err != nil {
+			return err
+		}
+		rw.lastRollTail = ""
 		if len(history) > 0 {
-			// Create new tail name using last history file name
-			newTail = rw.Self.getNewHistoryFileNameTail(rw.getFileTail(history[len(history)-1]))
-		} else {
-			// Create first tail name
-			newTail = rw.Self.getNewHistoryFileNameTail("")
+			rw.lastRollTail = rw.getFileTail(history[len(history)-1])
 		}
+		rw.lastRollTailKnown = true
+	}
 
-		if len(newTail) != 0 {
-			newHistoryName = rw.FileName + rollingLogHistoryDelimiter + newTail
-		} else {
-			newHistoryName = rw.FileName
+	// Renames current file to create a new roll history entry
+	// For file roller it may be like this:
+	//     * ...
+	//     * file.log.4
+	//     * file.log.5
+	//     * file.log.6
+	//     n file.log.7  <---- RENAMED (from file.log)
+	// Time rollers that doesn't modify file names (e.g. 'date' roller) skip this logic,
+	// unless the history is kept in another directory.
+	//
+	newHistoryName := rw.getNewHistoryFileName(rw.lastRollTail)
+
+	// Files left by previous runs or other writers may already use the name.
+	// History is never overwritten: the next free name is taken instead.
+	for rw.historyFileExists(newHistoryName) {
+		nextHistoryName := rw.getNewHistoryFileName(rw.getFileTail(newHistoryName))
+		if nextHistoryName == newHistoryName {
+			break
 		}
+		newHistoryName = nextHistoryName
+	}
 
-		if newHistoryName != rw.FileName {
-			err = os.Rename(filepath.Join(rw.CurrentDirPath, rw.FileName), filepath.Join(rw.CurrentDirPath, newHistoryName))
+	if newHistoryName != rw.FileName || rw.historyDirPath() != rw.CurrentDirPath {
+		err = rw.fs().Rename(filepath.Join(rw.CurrentDirPath, rw.FileName), filepath.Join(rw.historyDirPath(), newHistoryName))
+		if err != nil {
+			return err
+		}
+		if rw.Durability.durable() {
+			err = rw.syncDirs()
 			if err != nil {
-				return 0, err
+				return err
 			}
 		}
+	}
+	rw.lastRollTail = rw.getFileTail(newHistoryName)
+	roll.Path = filepath.Join(rw.historyDirPath(), newHistoryName)
+	if rw.Hooks.AfterRename != nil {
+		rw.Hooks.AfterRename(roll)
+	}
+
+	if rw.BackgroundRoll {
+		// Compression and removal of old rolls are left to the worker.
+		if rw.worker == nil {
+			rw.worker = newRollWorker(rw)
+		}
+		if rw.ProcessLocking {
+			// The worker waits for the exclusive lock held here, so it can not
+			// free the queue. A full queue is processed in place instead.
+			if !rw.worker.tryEnqueue(roll) {
+				rw.worker.drain()
+				err = rw.cleanupRoll(roll)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			rw.worker.enqueue(roll)
+		}
+	} else {
+		// Archive types that compress rolls one by one replace the new history
+		// file with its compressed version:
+		//     n file.log.7.gz  <---- COMPRESSED (from file.log.7)
+		newHistoryName, err = rw.compressRoll(newHistoryName, roll)
+		if err != nil {
+			return err
+		}
 
 		// Finally, add the newly added history file to the history archive
 		// and, if after that the archive exceeds the allowed max limit, older rolls
 		// must the removed/archived.
 		history = append(history, newHistoryName)
-		if len(history) > rw.MaxRolls {
-			err = rw.deleteOldRolls(history)
-			if err != nil {
-				return 0, err
-			}
+		err = rw.deleteOldRolls(history)
+		if err != nil {
+			return err
 		}
+	}
 
-		err = rw.createFileAndFolderIfNeeded()
-		if err != nil {
-			return 0, err
+	return rw.createFileAndFolderIfNeeded()
+}
+
+// syncDirs makes the rename of a roll durable by syncing both of the directories.
+func (rw *RollingFileWriter) syncDirs() error {
+	err := syncDir(rw.fs(), rw.historyDirPath())
+	if err != nil || rw.historyDirPath() == rw.CurrentDirPath {
+		return err
+	}
+	return syncDir(rw.fs(), rw.CurrentDirPath)
+}
+
+// getNewHistoryFileName returns the name for the current file in the history.
+// New tail is created using last history file tail or, if there is no history
+// yet, the first tail. It is appended to the tail of the current file.
+func (rw *RollingFileWriter) getNewHistoryFileName(lastRollTail string) string {
+	newTail := rw.Self.getNewHistoryFileNameTail(lastRollTail)
+	if currentTail := rw.getFileTail(rw.FileName); len(currentTail) != 0 && len(newTail) != 0 {
+		newTail = currentTail + rollingLogHistoryDelimiter + newTail
+	} else if len(newTail) == 0 {
+		newTail = currentTail
+	}
+	return rw.namer().FileName(rw.OriginalFileName, newTail)
+}
+
+// historyFileExists returns true if there is a history file with the given name,
+// either compressed or not. The current file does not count.
+func (rw *RollingFileWriter) historyFileExists(historyName string) bool {
+	if historyName == rw.currentHistoryFileName() {
+		return false
+	}
+	historyPath := filepath.Join(rw.historyDirPath(), historyName)
+	if _, err := rw.fs().Lstat(historyPath); err == nil {
+		return true
+	}
+	for _, suffix := range rollingArchiveTypesFileSuffixes {
+		if _, err := rw.fs().Lstat(historyPath + suffix); err == nil {
+			return true
 		}
 	}
+	return false
+}
+
+// cleanupRoll compresses the given history file and removes/archives the rolls
+// that exceed the allowed limit. It is run by the background roll worker.
+func (rw *RollingFileWriter) cleanupRoll(roll RollInfo) error {
+	rw.historyMutex.Lock()
+	defer rw.historyMutex.Unlock()
+
+	// The roll may be already archived or removed by a newer one processed in
+	// place, see roll.
+	if _, err := rw.fs().Lstat(roll.Path); os.IsNotExist(err) {
+		return nil
+	}
 
-	rw.CurrentFileSize += int64(len(bytes))
-	return rw.CurrentFile.Write(bytes)
+	historyName, err := rw.compressRoll(filepath.Base(roll.Path), roll)
+	if err != nil {
+		return err
+	}
+
+	history, err := rw.getSortedLogHistory("")
+	if err != nil {
+		return err
+	}
+
+	// Files newer than the processed roll (including the current file of
+	// time rollers) are left to the jobs of later rolls.
+	tail := rw.getFileTail(historyName)
+	last := -1
+	for i, file := range history {
+		if rw.getFileTail(file) == tail {
+			last = i
+		}
+	}
+	if last < 0 {
+		return nil
+	}
+	return rw.deleteOldRolls(history[:last+1])
+}
+
+// Reopen closes the current file and opens it again by its name, updating the
+// current file size. It lets external tools like logrotate move or truncate the
+// file. Does nothing if the file is not open yet.
+func (rw *RollingFileWriter) Reopen() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	// The fallback file is opened again by the next write.
+	err := rw.closeFallbackFile()
+	if err != nil {
+		return err
+	}
+	if rw.CurrentFile == nil {
+		return nil
+	}
+	err = rw.syncer.finish(rw.CurrentFile, rw.Durability)
+	if err != nil {
+		return err
+	}
+	err = rw.CurrentFile.Close()
+	if err != nil {
+		return err
+	}
+	rw.CurrentFile = nil
+	return rw.createFileAndFolderIfNeeded()
 }
 
 func (rw *RollingFileWriter) Close() error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	// Wait until all pending background work is done.
+	if rw.worker != nil {
+		rw.worker.stop()
+		rw.worker = nil
+	}
+	if rw.processLockFile != nil {
+		rw.processLockFile.Close()
+		rw.processLockFile = nil
+	}
+	fallbackErr := rw.closeFallbackFile()
 	if rw.CurrentFile != nil {
+		syncErr := rw.syncer.finish(rw.CurrentFile, rw.Durability)
 		e := rw.CurrentFile.Close()
 		if e != nil {
 			return e
 		}
 		rw.CurrentFile = nil
+		if syncErr != nil {
+			return syncErr
+		}
 	}
-	return nil
+	return fallbackErr
 }
 
 // =============================================================================================
@@ -430,6 +1130,9 @@ func (rws *RollingFileWriterSize) getCurrentModifiedFileName(OriginalFileName st
 }
 
 func (rws *RollingFileWriterSize) String() string {
+	rws.mutex.Lock()
+	defer rws.mutex.Unlock()
+
 	return fmt.Sprintf("Rolling file writer (By SIZE): filename: %s, archive: %s, archivefile: %s, MaxFileSize: %v, MaxRolls: %v",
 		rws.FileName,
 		RollingArchiveTypesStringRepresentation[rws.ArchiveType],
@@ -443,11 +1146,15 @@ func (rws *RollingFileWriterSize) String() string {
 // --------------------------------------------------
 
 // RollingFileWriterTime performs roll when a specified time Interval has passed.
+// Intervals are aligned to calendar boundaries in Location (time.Local if nil),
+// e.g. a daily writer rolls at midnight regardless of when the file was created.
 type RollingFileWriterTime struct {
 	*RollingFileWriter
 	TimePattern         string
 	Interval            RollingIntervalType
 	CurrentTimeFileName string
+	IntervalDuration    time.Duration // Used with RollingIntervalDuration
+	Location            *time.Location
 }
 
 func NewRollingFileWriterTime(fpath string, atype RollingArchiveType, apath string, maxr int,
@@ -457,55 +1164,99 @@ func NewRollingFileWriterTime(fpath string, atype RollingArchiveType, apath stri
 	if err != nil {
 		return nil, err
 	}
-	rws := &RollingFileWriterTime{rw, TimePattern, Interval, ""}
+	rws := &RollingFileWriterTime{rw, TimePattern, Interval, "", 0, nil}
 	rws.Self = rws
 	return rws, nil
 }
 
+func (rwt *RollingFileWriterTime) location() *time.Location {
+	if rwt.Location == nil {
+		return time.Local
+	}
+	return rwt.Location
+}
+
 func (rwt *RollingFileWriterTime) needsToRoll() (bool, error) {
-	if rwt.OriginalFileName+rollingLogHistoryDelimiter+time.Now().Format(rwt.TimePattern) == rwt.FileName {
+	now := rwt.clock().Now().In(rwt.location())
+	if rwt.namer().FileName(rwt.OriginalFileName, now.Format(rwt.TimePattern)) == rwt.FileName {
 		return false, nil
 	}
 	if rwt.Interval == RollingIntervalAny {
 		return true, nil
 	}
 
-	tprev, err := time.ParseInLocation(rwt.TimePattern, rwt.getFileTail(rwt.FileName), time.Local)
+	tprev, err := time.ParseInLocation(rwt.TimePattern, rwt.getFileTail(rwt.FileName), rwt.location())
 	if err != nil {
 		return false, err
 	}
 
-	diff := time.Now().Sub(tprev)
-	switch rwt.Interval {
+	prevStart, err := rollingPeriodStart(tprev, rwt.Interval, rwt.IntervalDuration, rwt.location())
+	if err != nil {
+		return false, err
+	}
+	nowStart, err := rollingPeriodStart(now, rwt.Interval, rwt.IntervalDuration, rwt.location())
+	if err != nil {
+		return false, err
+	}
+	return nowStart.After(prevStart), nil
+}
+
+// rollingPeriodStart returns the start of the interval period that contains t.
+// Periods are aligned to the calendar in loc: to hours, midnights, Mondays and
+// first days of months. Custom durations up to a day are counted from midnight,
+// longer ones from the zero time.
+func rollingPeriodStart(t time.Time, interval RollingIntervalType, d time.Duration, loc *time.Location) (time.Time, error) {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch interval {
+	case RollingIntervalHourly:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, loc), nil
 	case RollingIntervalDaily:
-		return diff >= 24*time.Hour, nil
+		return time.Date(year, month, day, 0, 0, 0, 0, loc), nil
+	case RollingIntervalWeekly:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, loc), nil
+	case RollingIntervalMonthly:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc), nil
+	case RollingIntervalDuration:
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("interval duration must be positive. Got: %v", d)
+		}
+		if d <= 24*time.Hour {
+			midnight := time.Date(year, month, day, 0, 0, 0, 0, loc)
+			return midnight.Add(t.Sub(midnight) / d * d), nil
+		}
+		_, offset := t.Zone()
+		zoneOffset := time.Duration(offset) * time.Second
+		return t.Add(zoneOffset).Truncate(d).Add(-zoneOffset).In(loc), nil
 	}
-	return false, fmt.Errorf("unknown Interval type: %d", rwt.Interval)
+	return time.Time{}, fmt.Errorf("unknown Interval type: %d", interval)
 }
 
 func (rwt *RollingFileWriterTime) isFileTailValid(tail string) bool {
 	if len(tail) == 0 {
 		return false
 	}
-	_, err := time.ParseInLocation(rwt.TimePattern, tail, time.Local)
+	_, err := time.ParseInLocation(rwt.TimePattern, tail, rwt.location())
 	return err == nil
 }
 
 type rollTimeFileTailsSlice struct {
-	data    []string
-	pattern string
+	data     []string
+	pattern  string
+	location *time.Location
 }
 
 func (p rollTimeFileTailsSlice) Len() int { return len(p.data) }
 func (p rollTimeFileTailsSlice) Less(i, j int) bool {
-	t1, _ := time.ParseInLocation(p.pattern, p.data[i], time.Local)
-	t2, _ := time.ParseInLocation(p.pattern, p.data[j], time.Local)
+	t1, _ := time.ParseInLocation(p.pattern, p.data[i], p.location)
+	t2, _ := time.ParseInLocation(p.pattern, p.data[j], p.location)
 	return t1.Before(t2)
 }
 func (p rollTimeFileTailsSlice) Swap(i, j int) { p.data[i], p.data[j] = p.data[j], p.data[i] }
 
 func (rwt *RollingFileWriterTime) sortFileTailsAsc(fs []string) ([]string, error) {
-	ss := rollTimeFileTailsSlice{data: fs, pattern: rwt.TimePattern}
+	ss := rollTimeFileTailsSlice{data: fs, pattern: rwt.TimePattern, location: rwt.location()}
 	sort.Sort(ss)
 	return ss.data, nil
 }
@@ -515,10 +1266,13 @@ func (rwt *RollingFileWriterTime) getNewHistoryFileNameTail(lastRollFileTail str
 }
 
 func (rwt *RollingFileWriterTime) getCurrentModifiedFileName(OriginalFileName string) string {
-	return OriginalFileName + rollingLogHistoryDelimiter + time.Now().Format(rwt.TimePattern)
+	return rwt.namer().FileName(OriginalFileName, rwt.clock().Now().In(rwt.location()).Format(rwt.TimePattern))
 }
 
 func (rwt *RollingFileWriterTime) String() string {
+	rwt.mutex.Lock()
+	defer rwt.mutex.Unlock()
+
 	return fmt.Sprintf("Rolling file writer (By TIME): filename: %s, archive: %s, archivefile: %s, maxInterval: %v, pattern: %s, MaxRolls: %v",
 		rwt.FileName,
 		RollingArchiveTypesStringRepresentation[rwt.ArchiveType],
@@ -528,14 +1282,112 @@ func (rwt *RollingFileWriterTime) String() string {
 		rwt.MaxRolls)
 }
 
+// --------------------------------------------------
+//      Rolling writer by SIZE and TIME
+// --------------------------------------------------
+
+// RollingFileWriterSizeTime performs roll when file exceeds a specified limit or
+// when a specified time Interval has passed, whichever comes first. Rolls of the
+// same period are numbered, e.g. file.log.2026-10-16.1, file.log.2026-10-16.2.
+type RollingFileWriterSizeTime struct {
+	*RollingFileWriterTime
+	MaxFileSize int64
+}
+
+func NewRollingFileWriterSizeTime(fpath string, atype RollingArchiveType, apath string, maxSize int64, maxr int,
+	TimePattern string, Interval RollingIntervalType) (*RollingFileWriterSizeTime, error) {
+
+	rw, err := NewRollingFileWriter(fpath, RollingTypeSizeTime, atype, apath, maxr)
+	if err != nil {
+		return nil, err
+	}
+	rwt := &RollingFileWriterTime{rw, TimePattern, Interval, "", 0, nil}
+	rwst := &RollingFileWriterSizeTime{rwt, maxSize}
+	rwst.Self = rwst
+	return rwst, nil
+}
+
+func (rwst *RollingFileWriterSizeTime) needsToRoll() (bool, error) {
+	if rwst.CurrentFileSize >= rwst.MaxFileSize {
+		return true, nil
+	}
+	return rwst.RollingFileWriterTime.needsToRoll()
+}
+
+// splitFileTail splits the roll file tail into its time part and roll number.
+func (rwst *RollingFileWriterSizeTime) splitFileTail(tail string) (timeTail string, index int, ok bool) {
+	i := strings.LastIndex(tail, rollingLogHistoryDelimiter)
+	if i <= 0 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(tail[i+len(rollingLogHistoryDelimiter):])
+	if err != nil {
+		return "", 0, false
+	}
+	timeTail = tail[:i]
+	return timeTail, index, rwst.RollingFileWriterTime.isFileTailValid(timeTail)
+}
+
+func (rwst *RollingFileWriterSizeTime) isFileTailValid(tail string) bool {
+	_, _, ok := rwst.splitFileTail(tail)
+	return ok
+}
+
+type rollSizeTimeFileTailsSlice struct {
+	data []string
+	rwst *RollingFileWriterSizeTime
+}
+
+func (p rollSizeTimeFileTailsSlice) Len() int { return len(p.data) }
+func (p rollSizeTimeFileTailsSlice) Less(i, j int) bool {
+	tail1, v1, _ := p.rwst.splitFileTail(p.data[i])
+	tail2, v2, _ := p.rwst.splitFileTail(p.data[j])
+	t1, _ := time.ParseInLocation(p.rwst.TimePattern, tail1, p.rwst.location())
+	t2, _ := time.ParseInLocation(p.rwst.TimePattern, tail2, p.rwst.location())
+	if t1.Equal(t2) {
+		return v1 < v2
+	}
+	return t1.Before(t2)
+}
+func (p rollSizeTimeFileTailsSlice) Swap(i, j int) { p.data[i], p.data[j] = p.data[j], p.data[i] }
+
+func (rwst *RollingFileWriterSizeTime) sortFileTailsAsc(fs []string) ([]string, error) {
+	ss := rollSizeTimeFileTailsSlice{data: fs, rwst: rwst}
+	sort.Sort(ss)
+	return ss.data, nil
+}
+
+// getNewHistoryFileNameTail returns the next roll number of the current period.
+// It is appended to the current file name, which already contains the time part.
+func (rwst *RollingFileWriterSizeTime) getNewHistoryFileNameTail(lastRollFileTail string) string {
+	v := 0
+	if timeTail, index, ok := rwst.splitFileTail(lastRollFileTail); ok && timeTail == rwst.getFileTail(rwst.FileName) {
+		v = index
+	}
+	return fmt.Sprintf("%d", v+1)
+}
+
+func (rwst *RollingFileWriterSizeTime) String() string {
+	rwst.mutex.Lock()
+	defer rwst.mutex.Unlock()
+
+	return fmt.Sprintf("Rolling file writer (By SIZE and TIME): filename: %s, archive: %s, archivefile: %s, MaxFileSize: %v, maxInterval: %v, pattern: %s, MaxRolls: %v",
+		rwst.FileName,
+		RollingArchiveTypesStringRepresentation[rwst.ArchiveType],
+		rwst.ArchivePath,
+		rwst.MaxFileSize,
+		rwst.Interval,
+		rwst.TimePattern,
+		rwst.MaxRolls)
+}
+
 // getDirFilePaths return full paths of the files located in the directory.
 // Remark: Ignores files for which fileFilter returns false.
-func getDirFilePaths(dirPath string, fpFilter filePathFilter, pathIsName bool) ([]string, error) {
-	dfi, err := os.Open(dirPath)
+func getDirFilePaths(fs FileSystem, dirPath string, fpFilter filePathFilter, pathIsName bool) ([]string, error) {
+	fis, err := fs.ReadDir(dirPath)
 	if err != nil {
 		return nil, newCannotOpenFileError("Cannot open directory " + dirPath)
 	}
-	defer dfi.Close()
 
 	var absDirPath string
 	if !filepath.IsAbs(dirPath) {
@@ -547,43 +1399,22 @@ func getDirFilePaths(dirPath string, fpFilter filePathFilter, pathIsName bool) (
 		absDirPath = dirPath
 	}
 
-	// TODO: check if dirPath is really directory.
-	// Size of read buffer (i.e. chunk of items read at a time).
-	rbs := 2 << 5
 	filePaths := []string{}
-
 	var fp string
-L:
-	for {
-		// Read directory entities by reasonable chuncks
-		// to prevent overflows on big number of files.
-		fis, e := dfi.Readdir(rbs)
-		switch e {
-		// It's OK.
-		case nil:
-		// Do nothing, just continue cycle.
-		case io.EOF:
-			break L
-		// Indicate that something went wrong.
-		default:
-			return nil, e
-		}
-		// THINK: Maybe, use async running.
-		for _, fi := range fis {
-			// NB: Should work on every Windows and non-Windows OS.
-			if isRegular(fi.Mode()) {
-				if pathIsName {
-					fp = fi.Name()
-				} else {
-					// Build full path of a file.
-					fp = filepath.Join(absDirPath, fi.Name())
-				}
-				// Check filter condition.
-				if fpFilter != nil && !fpFilter(fp) {
-					continue
-				}
-				filePaths = append(filePaths, fp)
+	for _, fi := range fis {
+		// NB: Should work on every Windows and non-Windows OS.
+		if isRegular(fi.Mode()) {
+			if pathIsName {
+				fp = fi.Name()
+			} else {
+				// Build full path of a file.
+				fp = filepath.Join(absDirPath, fi.Name())
+			}
+			// Check filter condition.
+			if fpFilter != nil && !fpFilter(fp) {
+				continue
 			}
+			filePaths = append(filePaths, fp)
 		}
 	}
 	return filePaths, nil
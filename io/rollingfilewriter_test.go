@@ -25,9 +25,17 @@
 package io
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func createRollingSizeFileWriterTestCase(
@@ -51,6 +59,18 @@ func createRollingDatefileWriterTestCase(
 	return &fileWriterTestCase{files, fileName, RollingTypeTime, 0, 0, datePattern, writeCount, resFiles}
 }
 
+func createRollingSizeDatefileWriterTestCase(
+	files []string,
+	fileName string,
+	fileSize int64,
+	maxRolls int,
+	datePattern string,
+	writeCount int,
+	resFiles []string) *fileWriterTestCase {
+
+	return &fileWriterTestCase{files, fileName, RollingTypeSizeTime, fileSize, maxRolls, datePattern, writeCount, resFiles}
+}
+
 func TestRollingFileWriter(t *testing.T) {
 	t.Logf("Starting rolling file writer tests")
 	NewFileWriterTester(rollingfileWriterTests, rollingFileWriterGetter, t).test()
@@ -63,6 +83,8 @@ func rollingFileWriterGetter(testCase *fileWriterTestCase) (io.WriteCloser, erro
 		return NewRollingFileWriterSize(testCase.fileName, RollingArchiveNone, "", testCase.fileSize, testCase.maxRolls)
 	} else if testCase.rollingType == RollingTypeTime {
 		return NewRollingFileWriterTime(testCase.fileName, RollingArchiveNone, "", -1, testCase.datePattern, RollingIntervalDaily)
+	} else if testCase.rollingType == RollingTypeSizeTime {
+		return NewRollingFileWriterSizeTime(testCase.fileName, RollingArchiveNone, "", testCase.fileSize, testCase.maxRolls, testCase.datePattern, RollingIntervalDaily)
 	}
 
 	return nil, fmt.Errorf("incorrect rollingType")
@@ -92,4 +114,476 @@ var rollingfileWriterTests = []*fileWriterTestCase{
 	createRollingSizeFileWriterTestCase([]string{`././././log.testlog.9`}, `log.testlog`, 10, 1, 2, []string{`log.testlog`, `log.testlog.10`}),
 	createRollingSizeFileWriterTestCase([]string{"././dir/dir/log.testlog.a", "././dir/dir/log.testlog.1b"}, "dir/dir/log.testlog", 10, 1, 2, []string{"dir/dir/log.testlog", "dir/dir/log.testlog.1", "dir/dir/log.testlog.a", "dir/dir/log.testlog.1b"}),
 	// ====================
+	createRollingSizeDatefileWriterTestCase([]string{}, "log.testlog", 10, 10, testDatePattern, 1, []string{"log.testlog." + testDate}),
+	createRollingSizeDatefileWriterTestCase([]string{}, "log.testlog", 10, 10, testDatePattern, 3, []string{"log.testlog." + testDate, "log.testlog." + testDate + ".1", "log.testlog." + testDate + ".2"}),
+	createRollingSizeDatefileWriterTestCase([]string{"log.testlog." + testDate + ".3"}, "log.testlog", 10, 10, testDatePattern, 2, []string{"log.testlog." + testDate, "log.testlog." + testDate + ".3", "log.testlog." + testDate + ".4"}),
+	createRollingSizeDatefileWriterTestCase([]string{"log.testlog.2000-01-01.5"}, "log.testlog", 10, 1, testDatePattern, 2, []string{"log.testlog." + testDate, "log.testlog." + testDate + ".1"}),
+	createRollingSizeDatefileWriterTestCase([]string{"dir/log.testlog.2000-01-01.5"}, "dir/log.testlog", 10, 10, testDatePattern, 2, []string{"dir/log.testlog." + testDate, "dir/log.testlog." + testDate + ".1", "dir/log.testlog.2000-01-01.5"}),
+}
+
+const testDatePattern = "2006-01-02"
+
+var testDate = time.Now().Format(testDatePattern)
+
+func TestRollingFileWriterArchiveZip(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	fileName := filepath.Join("dir", "log.testlog")
+	writer, err := NewRollingFileWriterSize(fileName, RollingArchiveZip, "", 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolls into log.testlog.1, .2 and .3; the first two exceed MaxRolls.
+	for i := 0; i < 4; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join("dir", RollingArchiveTypesDefaultNames[RollingArchiveZip])
+	if writer.ArchivePath != archivePath {
+		t.Errorf("expected default archive path %s. Got: %s", archivePath, writer.ArchivePath)
+	}
+
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	expected := []string{"log.testlog.1", "log.testlog.2"}
+	if len(zr.File) != len(expected) {
+		t.Fatalf("expected %d archived files. Got: %d", len(expected), len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != expected[i] {
+			t.Errorf("expected archived file %s. Got: %s", expected[i], f.Name)
+		}
+		if f.UncompressedSize64 != uint64(len(bytesFileTest)) {
+			t.Errorf("unexpected size of archived file %s: %d", f.Name, f.UncompressedSize64)
+		}
+	}
+
+	for _, name := range expected {
+		if _, err := os.Stat(filepath.Join("dir", name)); !os.IsNotExist(err) {
+			t.Errorf("archived file %s must be removed", name)
+		}
+	}
+}
+
+func TestRollingFileWriterArchiveGzip(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// A roll compressed by a previous run must be taken into account.
+	if err := os.WriteFile("log.testlog.1.gz", nil, defaultFilePermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveGzip, "", 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolls into log.testlog.2 and .3; log.testlog.1.gz exceeds MaxRolls.
+	for i := 0; i < 3; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := getDirFilePaths(defaultFileSystem, ".", isWriterTestFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"log.testlog": true, "log.testlog.2.gz": true, "log.testlog.3.gz": true}
+	if len(files) != len(expected) {
+		t.Errorf("expected files %v. Got: %v", expected, files)
+	}
+	for _, f := range files {
+		if !expected[f] {
+			t.Errorf("unexpected file: %s", f)
+		}
+	}
+
+	f, err := os.Open("log.testlog.3.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytesFileTest) {
+		t.Errorf("unexpected content of compressed roll: %q", data)
+	}
+}
+
+func TestRollingFileWriterBackgroundRoll(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveGzip, "", 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.BackgroundRoll = true
+	writer.BackgroundQueueSize = 1
+	writer.BackgroundErrorHandler = func(err error) {
+		t.Errorf("unexpected background error: %s", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close must wait for all the pending compressions and removals.
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := getDirFilePaths(defaultFileSystem, ".", isWriterTestFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"log.testlog": true, "log.testlog.3.gz": true, "log.testlog.4.gz": true}
+	if len(files) != len(expected) {
+		t.Errorf("expected files %v. Got: %v", expected, files)
+	}
+	for _, f := range files {
+		if !expected[f] {
+			t.Errorf("unexpected file: %s", f)
+		}
+	}
+}
+
+func TestRollingFileWriterBackgroundErrors(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// The archive can not be created inside of a regular file.
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveZip, filepath.Join("log.testlog", "log.zip"), 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.BackgroundRoll = true
+	writer.BackgroundErrors = make(chan error, 1)
+
+	for i := 0; i < 3; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-writer.BackgroundErrors:
+		t.Logf("background error: %s", err)
+	default:
+		t.Error("expected an archivation error")
+	}
+}
+
+func TestRollingFileWriterSizeConcurrentWrites(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConcurrentWrites(t, writer)
+}
+
+func TestRollingFileWriterTimeConcurrentWrites(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// Rolls every time the millisecond part of the file name changes.
+	writer, err := NewRollingFileWriterTime("log.testlog", RollingArchiveNone, "", 0, "150405.000", RollingIntervalAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConcurrentWrites(t, writer)
+}
+
+// testConcurrentWrites hammers the writer from several goroutines and checks
+// that no data was lost or overwritten during rolls.
+func testConcurrentWrites(t *testing.T, writer io.WriteCloser) {
+	const goroutines, writes = 8, 100
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if _, err := writer.Write(bytesFileTest); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := getDirFilePaths(defaultFileSystem, ".", isWriterTestFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, f := range files {
+		stat, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.Size()%messageLen != 0 {
+			t.Errorf("file %s contains a broken message: size %d", f, stat.Size())
+		}
+		total += stat.Size()
+	}
+	if expected := int64(goroutines * writes * messageLen); total != expected {
+		t.Errorf("expected %d bytes in %d files. Got: %d", expected, len(files), total)
+	}
+}
+
+func TestRollingPeriodStart(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	// Friday, in the middle of the month.
+	now := time.Date(2026, time.October, 16, 14, 35, 20, 0, loc)
+
+	tests := []struct {
+		interval RollingIntervalType
+		duration time.Duration
+		expected time.Time
+	}{
+		{RollingIntervalHourly, 0, time.Date(2026, time.October, 16, 14, 0, 0, 0, loc)},
+		{RollingIntervalDaily, 0, time.Date(2026, time.October, 16, 0, 0, 0, 0, loc)},
+		{RollingIntervalWeekly, 0, time.Date(2026, time.October, 12, 0, 0, 0, 0, loc)},
+		{RollingIntervalMonthly, 0, time.Date(2026, time.October, 1, 0, 0, 0, 0, loc)},
+		{RollingIntervalDuration, 15 * time.Minute, time.Date(2026, time.October, 16, 14, 30, 0, 0, loc)},
+		{RollingIntervalDuration, 6 * time.Hour, time.Date(2026, time.October, 16, 12, 0, 0, 0, loc)},
+		{RollingIntervalDuration, 48 * time.Hour, time.Date(2026, time.October, 16, 0, 0, 0, 0, loc)},
+	}
+
+	for _, test := range tests {
+		// The location of the time itself must not matter.
+		start, err := rollingPeriodStart(now.UTC(), test.interval, test.duration, loc)
+		if err != nil {
+			t.Errorf("interval %d: %s", test.interval, err)
+			continue
+		}
+		if !start.Equal(test.expected) {
+			t.Errorf("interval %d (%v): expected %v. Got: %v", test.interval, test.duration, test.expected, start)
+		}
+	}
+
+	if _, err := rollingPeriodStart(now, RollingIntervalDuration, 0, loc); err == nil {
+		t.Error("expected an error for zero interval duration")
+	}
+	if _, err := rollingPeriodStart(now, RollingIntervalAny, 0, loc); err == nil {
+		t.Error("expected an error for interval without periods")
+	}
+}
+
+func TestRollingIntervalTypeFromString(t *testing.T) {
+	for tp, str := range rollingInvervalTypesStringRepresentation {
+		parsed, ok := RollingIntervalTypeFromString(str)
+		if !ok || parsed != tp {
+			t.Errorf("expected %s to be parsed into %d. Got: %d, %v", str, tp, parsed, ok)
+		}
+	}
+}
+
+func TestRollingFileWriterRetention(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// History left by a previous run: .1 is too old, .2 and .3 are too big together.
+	oldTime := time.Now().Add(-48 * time.Hour)
+	for i, size := range []int{10, 30, 20} {
+		name := fmt.Sprintf("log.testlog.%d", i+1)
+		if err := os.WriteFile(name, make([]byte, size), defaultFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := os.Chtimes(name, oldTime, oldTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.MaxAge = 24 * time.Hour
+	writer.MaxHistorySize = 40
+
+	checkFiles := func(expected ...string) {
+		files, err := getDirFilePaths(defaultFileSystem, ".", isWriterTestFile, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(expected) {
+			t.Errorf("expected files %v. Got: %v", expected, files)
+			return
+		}
+		for _, f := range expected {
+			if _, err := os.Stat(f); err != nil {
+				t.Errorf("expected file %s. Got: %v", f, files)
+			}
+		}
+	}
+
+	// Retention is applied on startup.
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles("log.testlog", "log.testlog.3")
+
+	// And on every roll: .3, .4 and .5 fit into the limit, .6 pushes .3 out.
+	for i := 0; i < 2; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFiles("log.testlog", "log.testlog.3", "log.testlog.4", "log.testlog.5")
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles("log.testlog", "log.testlog.4", "log.testlog.5", "log.testlog.6")
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollingFileWriterReopen(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// logrotate in 'copytruncate' mode empties the file.
+	if err := os.Truncate("log.testlog", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if writer.CurrentFileSize != 0 {
+		t.Errorf("expected file size to be reset. Got: %d", writer.CurrentFileSize)
+	}
+
+	// The file is not full anymore, so there is no roll.
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("log.testlog.1"); !os.IsNotExist(err) {
+		t.Errorf("unexpected roll after reopen")
+	}
+}
+
+func TestRollingFileWriterStatCheck(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	writer.StatCheckInterval = time.Nanosecond
+
+	for i := 0; i < 2; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A deleted file is recreated.
+	if err := os.Remove("log.testlog"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat("log.testlog")
+	if err != nil {
+		t.Fatalf("deleted file was not recreated: %s", err)
+	}
+	if stat.Size() != messageLen || writer.CurrentFileSize != messageLen {
+		t.Errorf("expected %d bytes. Got: %d in file, %d counted", messageLen, stat.Size(), writer.CurrentFileSize)
+	}
+
+	// The size of a truncated file is synchronized.
+	if err := os.Truncate("log.testlog", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if writer.CurrentFileSize != messageLen {
+		t.Errorf("expected %d bytes counted after truncation. Got: %d", messageLen, writer.CurrentFileSize)
+	}
+}
+
+func TestRollingFileWriterRecordFraming(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.RecordDelimiter = []byte("\n")
+
+	// The file is full after the first write, but the record is not finished.
+	for _, data := range []string{strings.Repeat("a", 20), "bb\n", "c\n"} {
+		if _, err := writer.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFiles(t, map[string]string{
+		"log.testlog.1": strings.Repeat("a", 20) + "bb\n",
+		"log.testlog":   "c\n",
+	})
 }
@@ -0,0 +1,293 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rollerRestorer is implemented by the rollers that keep a part of their state
+// in the current file name, so it has to be found among the files on startup.
+type rollerRestorer interface {
+	// restoreFiles is called with the names of the files in the current directory
+	// that belong to the writer. Returns the name of a file to continue writing
+	// to, or an empty string to start a new one.
+	restoreFiles(files []string) (string, error)
+}
+
+// restoreState rebuilds the roll state from the files left by previous runs, so
+// that a restart never overwrites or skips history:
+//   - temporary files of interrupted compressions and archivations are removed;
+//   - time rollers continue the file of the current period, if there is one,
+//     and move the files of past periods into the history directory;
+//   - rolls that were renamed but not compressed yet are compressed.
+//
+// The last roll tail is found by the history scan of the next roll.
+func (rw *RollingFileWriter) restoreState() error {
+	if _, err := rw.fs().Stat(rw.CurrentDirPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := rw.removeTemporaryFiles()
+	if err != nil {
+		return err
+	}
+
+	if restorer, ok := rw.Self.(rollerRestorer); ok {
+		files, err := getDirFilePaths(rw.fs(), rw.CurrentDirPath, rw.isOwnFile, true)
+		if err != nil {
+			return err
+		}
+		rw.resumedFileName, err = restorer.restoreFiles(files)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rw.compressLeftRolls()
+}
+
+// isOwnFile returns true if the file name belongs to the writer.
+func (rw *RollingFileWriter) isOwnFile(fileName string) bool {
+	_, ok := rw.namer().FileTail(rw.OriginalFileName, trimArchiveSuffix(fileName))
+	return ok
+}
+
+// removeTemporaryFiles removes the temporary files of interrupted compressions
+// of rolls and archive updates.
+func (rw *RollingFileWriter) removeTemporaryFiles() error {
+	fs := rw.fs()
+	if _, err := fs.Stat(rw.historyDirPath()); err == nil {
+		tmpFiles, err := getDirFilePaths(fs, rw.historyDirPath(), func(fileName string) bool {
+			if !strings.HasSuffix(fileName, rollingTemporaryFileSuffix) {
+				return false
+			}
+			fileName = strings.TrimSuffix(fileName, rollingTemporaryFileSuffix)
+			return trimArchiveSuffix(fileName) != fileName && rw.isOwnFile(fileName)
+		}, true)
+		if err != nil {
+			return err
+		}
+		for _, tmpFile := range tmpFiles {
+			if err = tryRemoveFile(fs, filepath.Join(rw.historyDirPath(), tmpFile)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(rw.ArchivePath) == 0 {
+		return nil
+	}
+	archiveDir := filepath.Dir(rw.ArchivePath)
+	if _, err := fs.Stat(archiveDir); err != nil {
+		return nil
+	}
+	pattern := filepath.Base(rw.ArchivePath) + ".*" + rollingTemporaryFileSuffix
+	tmpFiles, err := getDirFilePaths(fs, archiveDir, func(fileName string) bool {
+		matched, _ := filepath.Match(pattern, fileName)
+		return matched
+	}, true)
+	if err != nil {
+		return err
+	}
+	for _, tmpFile := range tmpFiles {
+		if err = tryRemoveFile(fs, filepath.Join(archiveDir, tmpFile)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressLeftRolls compresses the history files that were left uncompressed
+// by a previous run. Files that already have a compressed copy are removed:
+// compressed files are created by rename, so they are always complete.
+func (rw *RollingFileWriter) compressLeftRolls() error {
+	if _, ok := rollingArchiveTypesFileSuffixes[rw.ArchiveType]; !ok {
+		return nil
+	}
+	if _, err := rw.fs().Stat(rw.historyDirPath()); os.IsNotExist(err) {
+		return nil
+	}
+
+	current := rw.currentHistoryFileName()
+	if len(rw.resumedFileName) != 0 && rw.historyDirPath() == rw.CurrentDirPath {
+		current = rw.resumedFileName
+	}
+	history, err := rw.getSortedLogHistory(current)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]bool, len(history))
+	for _, file := range history {
+		files[file] = true
+	}
+	for _, file := range history {
+		if trimArchiveSuffix(file) != file {
+			continue
+		}
+
+		compressed := false
+		for _, suffix := range rollingArchiveTypesFileSuffixes {
+			compressed = compressed || files[file+suffix]
+		}
+		if compressed {
+			err = tryRemoveFile(rw.fs(), filepath.Join(rw.historyDirPath(), file))
+		} else {
+			_, err = rw.compressRoll(file, RollInfo{})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFiles finds the newest uncompressed file of the current time period.
+// Files of past periods are moved into the history directory, if it is another
+// one, so that the retention limits apply to them.
+func (rwt *RollingFileWriterTime) restoreFiles(files []string) (string, error) {
+	resumed, err := rwt.resumedFile(files)
+	if err != nil || rwt.historyDirPath() == rwt.CurrentDirPath {
+		return resumed, err
+	}
+
+	moved := false
+	for _, file := range files {
+		tail, ok := rwt.namer().FileTail(rwt.OriginalFileName, file)
+		if file == resumed || !ok || !rwt.isFileTailValid(tail) {
+			continue
+		}
+		// History is never overwritten: a file with a taken name is left in place.
+		if rwt.historyFileExists(file) {
+			continue
+		}
+		err = rwt.moveToHistory(file, file)
+		if err != nil {
+			return "", err
+		}
+		moved = true
+	}
+	if moved && rwt.Durability.durable() {
+		err = rwt.syncDirs()
+	}
+	return resumed, err
+}
+
+// resumedFile returns the newest uncompressed file of the current time period.
+func (rwt *RollingFileWriterTime) resumedFile(files []string) (string, error) {
+	now := rwt.clock().Now().In(rwt.location())
+	nowTail := now.Format(rwt.TimePattern)
+	var nowStart time.Time
+	if rwt.Interval != RollingIntervalAny {
+		var err error
+		nowStart, err = rollingPeriodStart(now, rwt.Interval, rwt.IntervalDuration, rwt.location())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var resumed string
+	var resumedTime time.Time
+	for _, file := range files {
+		tail, ok := rwt.namer().FileTail(rwt.OriginalFileName, file)
+		if !ok || !rwt.isFileTailValid(tail) {
+			continue
+		}
+		t, _ := time.ParseInLocation(rwt.TimePattern, tail, rwt.location())
+
+		if rwt.Interval == RollingIntervalAny {
+			if tail != nowTail {
+				continue
+			}
+		} else {
+			start, err := rollingPeriodStart(t, rwt.Interval, rwt.IntervalDuration, rwt.location())
+			if err != nil || !start.Equal(nowStart) {
+				continue
+			}
+		}
+
+		if len(resumed) == 0 || t.After(resumedTime) {
+			resumed, resumedTime = file, t
+		}
+	}
+	return resumed, nil
+}
+
+// restoreFiles finds the file of the current time period, and numbers the files
+// of past periods that were not rolled because the writer was stopped.
+func (rwst *RollingFileWriterSizeTime) restoreFiles(files []string) (string, error) {
+	resumed, err := rwst.resumedFile(files)
+	if err != nil {
+		return "", err
+	}
+
+	lastIndexes := make(map[string]int)
+	for _, file := range files {
+		if timeTail, index, ok := rwst.splitFileTail(rwst.getFileTail(file)); ok && index > lastIndexes[timeTail] {
+			lastIndexes[timeTail] = index
+		}
+	}
+
+	moved := false
+	for _, file := range files {
+		tail, ok := rwst.namer().FileTail(rwst.OriginalFileName, file)
+		if file == resumed || !ok || !rwst.RollingFileWriterTime.isFileTailValid(tail) {
+			continue
+		}
+
+		// The history directory may already hold rolls of the period.
+		var historyName string
+		for historyName == "" || rwst.historyFileExists(historyName) {
+			lastIndexes[tail]++
+			historyName = rwst.namer().FileName(rwst.OriginalFileName, tail+rollingLogHistoryDelimiter+fmt.Sprint(lastIndexes[tail]))
+		}
+		err = rwst.moveToHistory(file, historyName)
+		if err != nil {
+			return "", err
+		}
+		moved = true
+	}
+	if moved && rwst.Durability.durable() {
+		err = rwst.syncDirs()
+	}
+	return resumed, err
+}
+
+// moveToHistory moves a file left in the current directory into the history
+// directory under the history name.
+func (rw *RollingFileWriter) moveToHistory(file, historyName string) error {
+	if rw.historyDirPath() != rw.CurrentDirPath {
+		err := rw.Permissions.mkdirAll(rw.fs(), rw.historyDirPath())
+		if err != nil {
+			return err
+		}
+	}
+	return rw.fs().Rename(filepath.Join(rw.CurrentDirPath, file), filepath.Join(rw.historyDirPath(), historyName))
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestFiles(t *testing.T, files map[string]string) {
+	for name, content := range files {
+		if dir := filepath.Dir(name); dir != "." {
+			if err := os.MkdirAll(dir, defaultDirectoryPermissions); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(name, []byte(content), defaultFilePermissions); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkTestFiles(t *testing.T, expected map[string]string) {
+	files, err := getWriterTestResultFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(expected) {
+		t.Errorf("expected files %v. Got: %v", expected, files)
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Errorf("expected file %s: %s", name, err)
+			continue
+		}
+		if content != "*" && string(data) != content {
+			t.Errorf("unexpected content of %s: %q", name, data)
+		}
+	}
+}
+
+func TestRollingFileWriterRestoreCompression(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	createTestFiles(t, map[string]string{
+		"log.testlog.1":        "A",  // compressed copy exists
+		"log.testlog.1.gz":     "",   // stands for a complete compressed copy
+		"log.testlog.2":        "B",  // renamed, but not compressed
+		"log.testlog.3.gz.tmp": "",   // interrupted compression
+		"log.testlog":          "CC", // current file
+	})
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveGzip, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFiles(t, map[string]string{
+		"log.testlog.1.gz": "",
+		"log.testlog.2.gz": "*",
+		"log.testlog":      "CC" + string(bytesFileTest),
+	})
+}
+
+func TestRollingFileWriterRestoreTimePeriod(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// The first day of the month belongs to the current monthly period.
+	now := time.Now()
+	firstDay := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local).Format(testDatePattern)
+	createTestFiles(t, map[string]string{
+		"log.testlog.2000-01-01":  "old",
+		"log.testlog." + firstDay: "A",
+	})
+
+	writer, err := NewRollingFileWriterTime("log.testlog", RollingArchiveNone, "", 0, testDatePattern, RollingIntervalMonthly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFiles(t, map[string]string{
+		"log.testlog.2000-01-01":  "old",
+		"log.testlog." + firstDay: "A" + string(bytesFileTest),
+	})
+}
+
+func TestRollingFileWriterRestoreSizeTime(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	// The writer was stopped before the roll of 2000-01-01.
+	createTestFiles(t, map[string]string{
+		"log.testlog.2000-01-01.1": "A",
+		"log.testlog.2000-01-01":   "B",
+		"log.testlog." + testDate:  "C",
+	})
+
+	writer, err := NewRollingFileWriterSizeTime("log.testlog", RollingArchiveNone, "", 10, 0, testDatePattern, RollingIntervalDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFiles(t, map[string]string{
+		"log.testlog.2000-01-01.1": "A",
+		"log.testlog.2000-01-01.2": "B",
+		"log.testlog." + testDate:  "C" + string(bytesFileTest),
+	})
+}
+
+func TestRollingFileWriterRollNameClash(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewRollingFileWriterSize("log.testlog", RollingArchiveNone, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Background writers do not rescan the history on every roll.
+	writer.BackgroundRoll = true
+
+	for i := 0; i < 2; i++ {
+		if _, err := writer.Write(bytesFileTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Someone else took the next name.
+	createTestFiles(t, map[string]string{"log.testlog.2": "X"})
+
+	if _, err := writer.Write(bytesFileTest); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFiles(t, map[string]string{
+		"log.testlog.1": string(bytesFileTest),
+		"log.testlog.2": "X",
+		"log.testlog.3": string(bytesFileTest),
+		"log.testlog":   string(bytesFileTest),
+	})
+}
+
+func setMemRestoreWriter(fs *MemFileSystem, writer *RollingFileWriterTime) {
+	writer.FileSystem = fs
+	writer.Clock = NewFakeClock(time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC))
+	writer.Location = time.UTC
+	writer.HistoryDirPath = filepath.Join("logs", "hist")
+}
+
+func TestRollingFileWriterRestoreHistoryDir(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.WriteFile(filepath.Join("logs", "hist", "log.testlog.2026-10-14"), []byte("A"), 0644)
+	fs.WriteFile(filepath.Join("logs", "log.testlog.2026-10-14"), []byte("B"), 0644)
+	fs.WriteFile(filepath.Join("logs", "log.testlog.2026-10-15"), []byte("C"), 0644)
+
+	writer, err := NewRollingFileWriterTime(filepath.Join("logs", "log.testlog"), RollingArchiveNone, "", 0, "2006-01-02", RollingIntervalDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setMemRestoreWriter(fs, writer)
+	defer writer.Close()
+
+	// Past periods are moved into the history, which is never overwritten.
+	writeMessages(t, writer, 1)
+	checkMemDirFiles(t, fs, "logs", "log.testlog.2026-10-14", "log.testlog.2026-10-16")
+	checkMemDirFiles(t, fs, filepath.Join("logs", "hist"), "log.testlog.2026-10-14", "log.testlog.2026-10-15")
+	checkMemFileData(t, fs, filepath.Join("logs", "hist", "log.testlog.2026-10-14"), []byte("A"))
+	checkMemFileData(t, fs, filepath.Join("logs", "hist", "log.testlog.2026-10-15"), []byte("C"))
+
+	// And the retention limits apply to them.
+	writer.MaxRolls = 1
+	writer.Clock.(*FakeClock).Advance(24 * time.Hour)
+	writeMessages(t, writer, 1)
+	checkMemDirFiles(t, fs, filepath.Join("logs", "hist"), "log.testlog.2026-10-16")
+}
+
+func TestRollingFileWriterRestoreSizeTimeHistoryDir(t *testing.T) {
+	fs := NewMemFileSystem()
+	fs.WriteFile(filepath.Join("logs", "hist", "log.testlog.2026-10-15.1"), []byte("ROLL1"), 0644)
+	fs.WriteFile(filepath.Join("logs", "log.testlog.2026-10-15"), []byte("LEFT"), 0644)
+
+	writer, err := NewRollingFileWriterSizeTime(filepath.Join("logs", "log.testlog"), RollingArchiveNone, "", 100, 0, "2006-01-02", RollingIntervalDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setMemRestoreWriter(fs, writer.RollingFileWriterTime)
+	defer writer.Close()
+
+	writeMessages(t, writer, 1)
+	checkMemDirFiles(t, fs, filepath.Join("logs", "hist"), "log.testlog.2026-10-15.1", "log.testlog.2026-10-15.2")
+	checkMemFileData(t, fs, filepath.Join("logs", "hist", "log.testlog.2026-10-15.1"), []byte("ROLL1"))
+	checkMemFileData(t, fs, filepath.Join("logs", "hist", "log.testlog.2026-10-15.2"), []byte("LEFT"))
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2013 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"sync"
+)
+
+// Default size of the background roll queue.
+const defaultBackgroundQueueSize = 16
+
+// rollWorker performs compression and removal of old rolls for a rolling writer
+// in a separate goroutine. Rolls are processed in the order they were made.
+type rollWorker struct {
+	rw    *RollingFileWriter
+	queue chan RollInfo // history files to be processed
+	wg    sync.WaitGroup
+}
+
+func newRollWorker(rw *RollingFileWriter) *rollWorker {
+	size := rw.BackgroundQueueSize
+	if size <= 0 {
+		size = defaultBackgroundQueueSize
+	}
+
+	worker := &rollWorker{rw: rw, queue: make(chan RollInfo, size)}
+	worker.wg.Add(1)
+	go worker.run()
+	return worker
+}
+
+func (worker *rollWorker) run() {
+	defer worker.wg.Done()
+	for roll := range worker.queue {
+		if err := worker.cleanupRoll(roll); err != nil {
+			worker.reportError(err)
+		}
+	}
+}
+
+func (worker *rollWorker) cleanupRoll(roll RollInfo) error {
+	// History scans and removals must not overlap with other processes' rolls.
+	if worker.rw.ProcessLocking {
+		unlock, err := worker.rw.lockProcessesExclusive()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	return worker.rw.cleanupRoll(roll)
+}
+
+// enqueue schedules processing of a new history file. Blocks while the queue is full.
+func (worker *rollWorker) enqueue(roll RollInfo) {
+	worker.queue <- roll
+}
+
+// tryEnqueue schedules processing of a new history file if the queue is not full.
+func (worker *rollWorker) tryEnqueue(roll RollInfo) bool {
+	select {
+	case worker.queue <- roll:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain processes the queued rolls in the calling goroutine. The caller must
+// hold the exclusive process lock if ProcessLocking is set.
+func (worker *rollWorker) drain() {
+	for {
+		select {
+		case roll := <-worker.queue:
+			if err := worker.rw.cleanupRoll(roll); err != nil {
+				worker.reportError(err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// stop waits until all the queued rolls are processed and stops the goroutine.
+func (worker *rollWorker) stop() {
+	close(worker.queue)
+	worker.wg.Wait()
+}
+
+func (worker *rollWorker) reportError(err error) {
+	if worker.rw.BackgroundErrorHandler != nil {
+		worker.rw.BackgroundErrorHandler(err)
+		return
+	}
+	if worker.rw.BackgroundErrors != nil {
+		select {
+		case worker.rw.BackgroundErrors <- err:
+		default:
+		}
+	}
+}
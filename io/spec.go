@@ -0,0 +1,489 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriterSpec is a declarative description of a writer stack: a file writer,
+// rolling or not, optionally wrapped into a BufferedWriter. It lets logging
+// setups be changed by configuration instead of code.
+//
+// A spec is parsed from text by ParseWriterSpec. Three formats are recognized,
+// all of them with the same keys (case-insensitive):
+//
+//	path=app.log rolling=size maxSize=10MB maxRolls=5 archive=gzip bufferSize=4KB
+//	path="my logs/app.log",rolling=date,interval=daily,timePattern='2006-01-02 15'
+//
+//	{"path": "app.log", "rolling": "date", "interval": "daily", "maxRolls": 7}
+//
+//	# A flat YAML mapping, nesting is not supported
+//	path: app.log
+//	rolling: sizedate
+//	interval: 6h
+//	maxSize: 100MB
+//
+// Values containing spaces, commas or semicolons are quoted in the key=value
+// format, with double quotes as Go strings or with single quotes doubling the
+// quotes inside, as in YAML.
+// Sizes are given in bytes or with a KB, MB or GB suffix (1024 based). Intervals
+// are either the names of RollingIntervalType or Go durations like "15m".
+// Flush periods are Go durations or integer milliseconds.
+type WriterSpec struct {
+	Path           string
+	Rolling        string        // RollingTypesStringRepresentation value. No rolling if empty
+	MaxSize        int64         // Size limit of the size rollers
+	Interval       string        // Interval of the time rollers
+	TimePattern    string        // Time format of the time rollers. Derived from Interval if empty
+	Archive        string        // RollingArchiveTypesStringRepresentation value. "none" if empty
+	ArchivePath    string        // Path of the zip archive
+	MaxRolls       int           // 0 - no limit
+	MaxAge         time.Duration // 0 - no limit
+	MaxHistorySize int64         // 0 - no limit
+	BufferSize     int           // Size of the BufferedWriter. No buffering if 0
+	FlushPeriod    time.Duration // Flush period of the BufferedWriter. No periodic flushes if 0
+}
+
+// Time patterns used by time rollers if the spec does not set one.
+var writerSpecDefaultTimePatterns = map[RollingIntervalType]string{
+	RollingIntervalDaily:    "2006-01-02",
+	RollingIntervalHourly:   "2006-01-02T15",
+	RollingIntervalWeekly:   "2006-01-02",
+	RollingIntervalMonthly:  "2006-01",
+	RollingIntervalDuration: "2006-01-02T15-04-05",
+}
+
+// ParseWriterSpec parses a writer spec in any of the formats described in WriterSpec.
+func ParseWriterSpec(text string) (*WriterSpec, error) {
+	var values map[string]string
+	var err error
+
+	switch trimmed := strings.TrimSpace(text); {
+	case strings.HasPrefix(trimmed, "{"):
+		values, err = parseSpecJSON(trimmed)
+	case isSpecYAML(trimmed):
+		values, err = parseSpecYAML(trimmed)
+	default:
+		values, err = parseSpecKeyValues(trimmed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spec := new(WriterSpec)
+	for key, value := range values {
+		if err = spec.set(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return spec, nil
+}
+
+// set sets the field of the spec key. Keys are case-insensitive.
+func (spec *WriterSpec) set(key, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "path":
+		spec.Path = value
+	case "rolling":
+		spec.Rolling = value
+	case "maxsize":
+		spec.MaxSize, err = parseSpecSize(value)
+	case "interval":
+		spec.Interval = value
+	case "timepattern":
+		spec.TimePattern = value
+	case "archive":
+		spec.Archive = value
+	case "archivepath":
+		spec.ArchivePath = value
+	case "maxrolls":
+		spec.MaxRolls, err = strconv.Atoi(value)
+	case "maxage":
+		spec.MaxAge, err = time.ParseDuration(value)
+	case "maxhistorysize":
+		spec.MaxHistorySize, err = parseSpecSize(value)
+	case "buffersize":
+		var size int64
+		size, err = parseSpecSize(value)
+		spec.BufferSize = int(size)
+	case "flushperiod":
+		spec.FlushPeriod, err = parseSpecPeriod(value)
+	default:
+		return fmt.Errorf("unknown writer spec key: %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid writer spec value of %s: %s", key, err)
+	}
+	return nil
+}
+
+// NewWriterFromSpec parses the spec and creates the writer stack described by it.
+func NewWriterFromSpec(text string) (io.WriteCloser, error) {
+	spec, err := ParseWriterSpec(text)
+	if err != nil {
+		return nil, err
+	}
+	return spec.NewWriter()
+}
+
+// NewWriter creates the writer stack described by the spec.
+func (spec *WriterSpec) NewWriter() (io.WriteCloser, error) {
+	writer, err := spec.newFileWriter()
+	if err != nil {
+		return nil, err
+	}
+	if spec.BufferSize == 0 {
+		return writer, nil
+	}
+	if spec.FlushPeriod < 0 {
+		return nil, fmt.Errorf("flushPeriod can not be less than 0. Got: %s", spec.FlushPeriod)
+	}
+	// BufferedWriter takes the period in milliseconds.
+	return NewBufferedWriter(writer, spec.BufferSize, spec.FlushPeriod/time.Millisecond)
+}
+
+func (spec *WriterSpec) newFileWriter() (io.WriteCloser, error) {
+	if len(spec.Path) == 0 {
+		return nil, errors.New("writer spec has no path")
+	}
+	if len(spec.Rolling) == 0 {
+		return NewFileWriter(spec.Path)
+	}
+
+	rtype, ok := RollingTypeFromString(spec.Rolling)
+	if !ok {
+		return nil, fmt.Errorf("unknown rolling type: %s", spec.Rolling)
+	}
+	var atype RollingArchiveType = RollingArchiveNone
+	if len(spec.Archive) != 0 {
+		if atype, ok = RollingArchiveTypeFromString(spec.Archive); !ok {
+			return nil, fmt.Errorf("unknown archive type: %s", spec.Archive)
+		}
+	}
+	if rtype != RollingTypeTime && spec.MaxSize <= 0 {
+		return nil, fmt.Errorf("maxSize must be greater than 0 for %s rolling. Got: %d", spec.Rolling, spec.MaxSize)
+	}
+
+	var writer io.WriteCloser
+	var rw *RollingFileWriter
+	if rtype == RollingTypeSize {
+		rws, err := NewRollingFileWriterSize(spec.Path, atype, spec.ArchivePath, spec.MaxSize, spec.MaxRolls)
+		if err != nil {
+			return nil, err
+		}
+		writer, rw = rws, rws.RollingFileWriter
+	} else {
+		interval, intervalDuration, err := parseSpecInterval(spec.Interval)
+		if err != nil {
+			return nil, err
+		}
+		pattern := spec.TimePattern
+		if len(pattern) == 0 {
+			pattern = writerSpecDefaultTimePatterns[interval]
+		}
+
+		var rwt *RollingFileWriterTime
+		if rtype == RollingTypeTime {
+			rwt, err = NewRollingFileWriterTime(spec.Path, atype, spec.ArchivePath, spec.MaxRolls, pattern, interval)
+			writer = rwt
+		} else {
+			var rwst *RollingFileWriterSizeTime
+			rwst, err = NewRollingFileWriterSizeTime(spec.Path, atype, spec.ArchivePath, spec.MaxSize, spec.MaxRolls, pattern, interval)
+			if err == nil {
+				rwt, writer = rwst.RollingFileWriterTime, rwst
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		rwt.IntervalDuration = intervalDuration
+		rw = rwt.RollingFileWriter
+	}
+
+	rw.MaxAge = spec.MaxAge
+	rw.MaxHistorySize = spec.MaxHistorySize
+	return writer, nil
+}
+
+// String returns the spec in the key=value format.
+func (spec *WriterSpec) String() string {
+	values := map[string]string{
+		"path":        spec.Path,
+		"rolling":     spec.Rolling,
+		"interval":    spec.Interval,
+		"timePattern": spec.TimePattern,
+		"archive":     spec.Archive,
+		"archivePath": spec.ArchivePath,
+	}
+	if spec.MaxSize != 0 {
+		values["maxSize"] = strconv.FormatInt(spec.MaxSize, 10)
+	}
+	if spec.MaxRolls != 0 {
+		values["maxRolls"] = strconv.Itoa(spec.MaxRolls)
+	}
+	if spec.MaxAge != 0 {
+		values["maxAge"] = spec.MaxAge.String()
+	}
+	if spec.MaxHistorySize != 0 {
+		values["maxHistorySize"] = strconv.FormatInt(spec.MaxHistorySize, 10)
+	}
+	if spec.BufferSize != 0 {
+		values["bufferSize"] = strconv.Itoa(spec.BufferSize)
+	}
+	if spec.FlushPeriod != 0 {
+		values["flushPeriod"] = spec.FlushPeriod.String()
+	}
+
+	var pairs []string
+	for key, value := range values {
+		if len(value) != 0 {
+			pairs = append(pairs, key+"="+quoteSpecValue(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// parseSpecJSON parses a JSON object with string or number values.
+func parseSpecJSON(text string) (map[string]string, error) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		default:
+			return nil, fmt.Errorf("writer spec value of %s must be a string or a number", key)
+		}
+	}
+	return values, nil
+}
+
+// isSpecYAML returns true if the first key of the spec is followed by a colon
+// rather than by an equals sign.
+func isSpecYAML(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' || line == "---" {
+			continue
+		}
+		colon, equals := strings.Index(line, ":"), strings.Index(line, "=")
+		return colon >= 0 && (equals < 0 || colon < equals)
+	}
+	return false
+}
+
+// parseSpecYAML parses a flat YAML mapping of scalars, one "key: value" per line.
+func parseSpecYAML(text string) (map[string]string, error) {
+	values := make(map[string]string)
+	for i, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("writer spec line %d: nested values are not supported", i+1)
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("writer spec line %d: expected 'key: value'", i+1)
+		}
+		value, err := unquoteSpecValue(stripSpecComment(strings.TrimSpace(value)))
+		if err != nil {
+			return nil, fmt.Errorf("writer spec line %d: %s", i+1, err)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, nil
+}
+
+// parseSpecKeyValues parses key=value pairs separated by spaces, commas or semicolons.
+// Values containing the separators are quoted, see WriterSpec.
+func parseSpecKeyValues(text string) (map[string]string, error) {
+	fields, err := splitSpecKeyValues(text)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("writer spec: expected key=value, got %q", field)
+		}
+		value, err = unquoteSpecValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("writer spec: %s", err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func isSpecSeparator(c byte) bool {
+	return c == ',' || c == ';' || c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// splitSpecKeyValues splits the text on the separators outside of quotes.
+func splitSpecKeyValues(text string) ([]string, error) {
+	var fields []string
+	var quote byte
+	start := -1
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case isSpecSeparator(c):
+			if start >= 0 {
+				fields = append(fields, text[start:i])
+				start = -1
+			}
+			continue
+		case c == '"' || c == '\'':
+			quote = c
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("writer spec: unterminated quoted value in %q", text[start:])
+	}
+	if start >= 0 {
+		fields = append(fields, text[start:])
+	}
+	return fields, nil
+}
+
+// quoteSpecValue quotes the value if it would not be parsed back as is.
+func quoteSpecValue(value string) string {
+	if strings.ContainsAny(value, ",; \t\n\r\"'") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+func stripSpecComment(value string) string {
+	if len(value) != 0 && (value[0] == '"' || value[0] == '\'') {
+		return value
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		return strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+func unquoteSpecValue(value string) (string, error) {
+	if len(value) < 2 {
+		return value, nil
+	}
+	switch value[0] {
+	case '"':
+		return strconv.Unquote(value)
+	case '\'':
+		if value[len(value)-1] != '\'' {
+			return "", fmt.Errorf("unterminated quoted value: %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
+
+var specSizeSuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"B", 1},
+}
+
+// parseSpecSize parses a size in bytes with an optional KB, MB or GB suffix.
+func parseSpecSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	multiplier := int64(1)
+	for _, s := range specSizeSuffixes {
+		if strings.HasSuffix(strings.ToUpper(value), s.suffix) {
+			value = strings.TrimSpace(value[:len(value)-len(s.suffix)])
+			multiplier = s.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("size can not be negative: %d", size)
+	}
+	return size * multiplier, nil
+}
+
+// parseSpecPeriod parses a Go duration or integer milliseconds.
+func parseSpecPeriod(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// parseSpecInterval parses an interval name or a Go duration.
+func parseSpecInterval(value string) (RollingIntervalType, time.Duration, error) {
+	if len(value) == 0 {
+		return 0, 0, errors.New("writer spec has no interval for time rolling")
+	}
+	if interval, ok := RollingIntervalTypeFromString(value); ok && interval != RollingIntervalDuration {
+		return interval, 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("invalid rolling interval: %s", value)
+	}
+	return RollingIntervalDuration, d, nil
+}
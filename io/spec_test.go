@@ -0,0 +1,200 @@
+// Copyright (c) 2012 - Cloud Instruments Co., Ltd.
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package io
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testWriterSpec = &WriterSpec{
+	Path:        "log.testlog",
+	Rolling:     "size",
+	MaxSize:     10 << 20,
+	Archive:     "gzip",
+	MaxRolls:    5,
+	MaxAge:      72 * time.Hour,
+	BufferSize:  4 << 10,
+	FlushPeriod: time.Second,
+}
+
+func TestParseWriterSpec(t *testing.T) {
+	specs := []string{
+		"path=log.testlog rolling=size maxSize=10MB archive=gzip maxRolls=5 maxAge=72h bufferSize=4KB flushPeriod=1000",
+		"path=log.testlog,rolling=size,maxsize=10485760,archive=gzip,maxrolls=5,maxage=72h,buffersize=4096,flushperiod=1s",
+		`{"path": "log.testlog", "rolling": "size", "maxSize": "10MB", "archive": "gzip",
+			"maxRolls": 5, "maxAge": "72h", "bufferSize": 4096, "flushPeriod": "1s"}`,
+		`
+# Rolled by size
+---
+path: "log.testlog"
+rolling: size
+maxSize: 10 MB # Before compression
+archive: 'gzip'
+maxRolls: 5
+maxAge: 72h
+bufferSize: 4kb
+flushPeriod: 1s
+`,
+		testWriterSpec.String(),
+	}
+
+	for _, text := range specs {
+		spec, err := ParseWriterSpec(text)
+		if err != nil {
+			t.Errorf("%q: %s", text, err)
+			continue
+		}
+		if !reflect.DeepEqual(spec, testWriterSpec) {
+			t.Errorf("%q: expected %+v. Got: %+v", text, testWriterSpec, spec)
+		}
+	}
+}
+
+func TestParseWriterSpecQuoted(t *testing.T) {
+	expected := &WriterSpec{Path: "my logs/log.testlog", Rolling: "date", Interval: "daily", TimePattern: "2006-01-02 15,04"}
+	specs := []string{
+		`path="my logs/log.testlog" rolling=date interval=daily timePattern="2006-01-02 15,04"`,
+		`path='my logs/log.testlog';rolling=date;interval=daily;timePattern='2006-01-02 15,04'`,
+		expected.String(),
+	}
+
+	for _, text := range specs {
+		spec, err := ParseWriterSpec(text)
+		if err != nil {
+			t.Errorf("%q: %s", text, err)
+			continue
+		}
+		if !reflect.DeepEqual(spec, expected) {
+			t.Errorf("%q: expected %+v. Got: %+v", text, expected, spec)
+		}
+	}
+
+	spec, err := ParseWriterSpec(`path='it''s "quoted".testlog' archivePath="a\tb.zip"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Path != `it's "quoted".testlog` || spec.ArchivePath != "a\tb.zip" {
+		t.Errorf("unexpected unquoted values: %+v", spec)
+	}
+}
+
+func TestParseWriterSpecErrors(t *testing.T) {
+	specs := []string{
+		"path=log.testlog unknown=1",
+		"path=log.testlog maxSize=10XB",
+		"path=log.testlog maxSize=-1",
+		"path log.testlog",
+		`path="log.testlog rolling=size`,
+		`path="log\.testlog"`,
+		`{"path": "log.testlog", "maxRolls": [1]}`,
+		"path: log.testlog\nrolling:\n  type: size",
+	}
+
+	for _, text := range specs {
+		if _, err := ParseWriterSpec(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestNewWriterFromSpec(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	writer, err := NewWriterFromSpec(testWriterSpec.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	bufWriter, ok := writer.(*BufferedWriter)
+	if !ok {
+		t.Fatalf("expected *BufferedWriter. Got: %T", writer)
+	}
+	if bufWriter.bufferSizeInBytes != 4<<10 || bufWriter.flushPeriod != time.Second {
+		t.Errorf("unexpected buffered writer: %s", bufWriter)
+	}
+	rws, ok := bufWriter.innerWriter.(*RollingFileWriterSize)
+	if !ok {
+		t.Fatalf("expected *RollingFileWriterSize. Got: %T", bufWriter.innerWriter)
+	}
+	if rws.MaxFileSize != 10<<20 || rws.ArchiveType != RollingArchiveGzip || rws.MaxRolls != 5 || rws.MaxAge != 72*time.Hour {
+		t.Errorf("unexpected rolling writer: %s", rws)
+	}
+}
+
+func TestNewWriterFromSpecTime(t *testing.T) {
+	cleanupWriterTest(t)
+	defer cleanupWriterTest(t)
+
+	tests := []struct {
+		spec             string
+		interval         RollingIntervalType
+		intervalDuration time.Duration
+		timePattern      string
+	}{
+		{"path=log.testlog rolling=date interval=daily", RollingIntervalDaily, 0, "2006-01-02"},
+		{"path=log.testlog rolling=date interval=15m timePattern=2006-01-02T15-04", RollingIntervalDuration, 15 * time.Minute, "2006-01-02T15-04"},
+		{"path=log.testlog rolling=sizedate interval=hourly maxSize=1MB", RollingIntervalHourly, 0, "2006-01-02T15"},
+	}
+
+	for _, test := range tests {
+		writer, err := NewWriterFromSpec(test.spec)
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+
+		var rwt *RollingFileWriterTime
+		switch w := writer.(type) {
+		case *RollingFileWriterTime:
+			rwt = w
+		case *RollingFileWriterSizeTime:
+			rwt = w.RollingFileWriterTime
+		default:
+			t.Fatalf("%q: unexpected writer %T", test.spec, writer)
+		}
+		if rwt.Interval != test.interval || rwt.IntervalDuration != test.intervalDuration || rwt.TimePattern != test.timePattern {
+			t.Errorf("%q: unexpected writer: %s", test.spec, rwt)
+		}
+		writer.Close()
+	}
+
+	for _, spec := range []string{
+		"rolling=size maxSize=1MB",
+		"path=log.testlog rolling=size",
+		"path=log.testlog rolling=date",
+		"path=log.testlog rolling=date interval=yearly",
+		"path=log.testlog rolling=weekly",
+		"path=log.testlog rolling=size maxSize=1MB archive=rar",
+	} {
+		if writer, err := NewWriterFromSpec(spec); err == nil {
+			writer.Close()
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}